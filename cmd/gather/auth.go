@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/mdsn/gather/lib/api"
+	"github.com/mdsn/gather/lib/ctl"
+	"github.com/mdsn/gather/lib/source"
+	"github.com/mdsn/gather/lib/source/manager"
+)
+
+// The access a client needs to run cmd. Commands on an attached source need
+// the access for its kind, so that file access does not allow stopping
+// processes.
+func required(cmd *api.Command, m *manager.Manager) ctl.Access {
+	switch cmd.Kind {
+	case api.CommandKindAdd:
+		return targetAccess(cmd.Target)
+	case api.CommandKindRm, api.CommandKindPause, api.CommandKindResume:
+		if st, ok := m.Status(cmd.Id); ok && st.Kind == source.KindProc {
+			return ctl.AccessProc
+		}
+		return ctl.AccessFile
+	default:
+		return ctl.AccessRead
+	}
+}
+
+func targetAccess(target api.CommandTarget) ctl.Access {
+	if target == api.CommandTargetProc {
+		return ctl.AccessProc
+	}
+	return ctl.AccessFile
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Completes subcommands, `ctl` commands and options, and the ids of the
+// sources attached to the running gather, as listed by `gather ctl ls`.
+const bashCompletion = `# bash completion for gather
+_gather_ids() {
+	local sock=()
+	local i
+	for ((i = 2; i < COMP_CWORD; i++)); do
+		case ${COMP_WORDS[i]} in
+		--socket=*) sock=("${COMP_WORDS[i]}") ;;
+		--socket) sock=(--socket "${COMP_WORDS[i+1]}") ;;
+		esac
+	done
+	"${COMP_WORDS[0]}" ctl "${sock[@]}" ls 2>/dev/null | awk '{ print $1 }'
+}
+
+_gather() {
+	local cur=${COMP_WORDS[COMP_CWORD]}
+	local prev=${COMP_WORDS[COMP_CWORD-1]}
+	COMPREPLY=()
+
+	if ((COMP_CWORD == 1)); then
+		COMPREPLY=($(compgen -W "ctl completion --help" -- "$cur"))
+		return
+	fi
+
+	case ${COMP_WORDS[1]} in
+	completion)
+		COMPREPLY=($(compgen -W "bash" -- "$cur"))
+		return
+		;;
+	ctl) ;;
+	*)
+		COMPREPLY=($(compgen -f -- "$cur"))
+		return
+		;;
+	esac
+
+	# Find the ctl command, skipping --socket and its value.
+	local cmd= i=2 n
+	while ((i < COMP_CWORD)); do
+		case ${COMP_WORDS[i]} in
+		--socket) ((i += 2)); continue ;;
+		-*) ((i++)); continue ;;
+		esac
+		cmd=${COMP_WORDS[i]}
+		break
+	done
+	n=$((COMP_CWORD - i))
+
+	if [[ -z $cmd ]]; then
+		if [[ $prev == --socket ]]; then
+			COMPREPLY=($(compgen -f -- "$cur"))
+		else
+			COMPREPLY=($(compgen -W "add rm pause resume ls stats tail subscribe history --socket" -- "$cur"))
+		fi
+		return
+	fi
+
+	case $cmd in
+	add)
+		case $n in
+		1) COMPREPLY=($(compgen -W "file dir proc" -- "$cur")) ;;
+		2) ;;
+		3)
+			if [[ ${COMP_WORDS[i+1]} == proc ]]; then
+				COMPREPLY=($(compgen -c -- "$cur"))
+			elif [[ ${COMP_WORDS[i+1]} == dir ]]; then
+				COMPREPLY=($(compgen -d -- "$cur"))
+			else
+				COMPREPLY=($(compgen -f -- "$cur"))
+			fi
+			;;
+		*)
+			if [[ ${COMP_WORDS[i+1]} == file ]]; then
+				COMPREPLY=($(compgen -W "--follow= --from= --lines= --fallback= --watch= --max-files=" -- "$cur"))
+				compopt -o nospace
+			elif [[ ${COMP_WORDS[i+1]} == dir ]]; then
+				COMPREPLY=($(compgen -W "--glob= --max-files= --max-depth= --include= --exclude= --from= --lines= --fallback=" -- "$cur"))
+				compopt -o nospace
+			else
+				COMPREPLY=($(compgen -f -- "$cur"))
+			fi
+			;;
+		esac
+		;;
+	rm)
+		((n == 1)) && COMPREPLY=($(compgen -W "$(_gather_ids)" -- "$cur"))
+		;;
+	pause | resume)
+		if [[ $cur == -* && $cmd == pause ]]; then
+			COMPREPLY=($(compgen -W "--discard --buffer=" -- "$cur"))
+			[[ $COMPREPLY == *= ]] && compopt -o nospace
+		elif [[ $cur == -* ]]; then
+			COMPREPLY=($(compgen -W "--skip" -- "$cur"))
+		else
+			COMPREPLY=($(compgen -W "$(_gather_ids)" -- "$cur"))
+		fi
+		;;
+	tail | subscribe)
+		if [[ $cur == -* ]]; then
+			COMPREPLY=($(compgen -W "--format=" -- "$cur"))
+			compopt -o nospace
+		else
+			COMPREPLY=($(compgen -W "$(_gather_ids)" -- "$cur"))
+		fi
+		;;
+	history)
+		if [[ $cur == -* ]]; then
+			COMPREPLY=($(compgen -W "-n --since= --follow --format=" -- "$cur"))
+			[[ $COMPREPLY == *= ]] && compopt -o nospace
+		elif [[ $prev != -n ]]; then
+			COMPREPLY=($(compgen -W "$(_gather_ids)" -- "$cur"))
+		fi
+		;;
+	esac
+}
+
+complete -F _gather gather
+`
+
+// `gather completion bash`: print the completion script for the shell.
+func runCompletion(args []string) int {
+	if len(args) != 1 || args[0] != "bash" {
+		fmt.Fprintln(os.Stderr, "usage: gather completion bash")
+		return 2
+	}
+	fmt.Print(bashCompletion)
+	return 0
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/mdsn/gather/lib/config"
+	"github.com/mdsn/gather/lib/source"
+	"github.com/mdsn/gather/lib/source/manager"
+)
+
+// Keeps the sources listed in a config file attached. Only sources that came
+// from the config are touched; those added over the socket are left alone.
+type reconciler struct {
+	path     string
+	stateDir string
+	// Specs attached from the config, by id.
+	applied map[string]*source.Spec
+}
+
+func newReconciler(path, stateDir string) *reconciler {
+	return &reconciler{
+		path:     path,
+		stateDir: stateDir,
+		applied:  make(map[string]*source.Spec),
+	}
+}
+
+// Read the config and bring the manager in line with it: new sources are
+// attached, removed ones detached, and changed ones restarted. Nothing is
+// changed if the config cannot be read.
+func (r *reconciler) reconcile(ctx context.Context, m *manager.Manager) error {
+	cmds, err := config.Load(r.path)
+	if err != nil {
+		return err
+	}
+
+	specs := make(map[string]*source.Spec, len(cmds))
+	for _, cmd := range cmds {
+		specs[cmd.Id] = makeSpec(cmd, r.stateDir)
+	}
+
+	for id := range r.applied {
+		if _, ok := specs[id]; ok {
+			continue
+		}
+		delete(r.applied, id)
+		if err := m.Remove(id); err != nil && !errors.Is(err, manager.ErrNotFound) {
+			log.Printf("config: remove: %v", err)
+			continue
+		}
+		log.Printf("config: removed source '%s'", id)
+	}
+
+	// In file order, so sources start up predictably.
+	for _, cmd := range cmds {
+		spec := specs[cmd.Id]
+		prev, ok := r.applied[spec.Id]
+		if ok && prev.Equal(spec) {
+			continue
+		}
+
+		if ok {
+			delete(r.applied, spec.Id)
+			if err := m.Remove(spec.Id); err != nil && !errors.Is(err, manager.ErrNotFound) {
+				log.Printf("config: remove: %v", err)
+				continue
+			}
+		}
+
+		// A failed source is tried again on the next reload.
+		if err := m.Attach(ctx, spec); err != nil {
+			log.Printf("config: attach: %v", err)
+			continue
+		}
+		r.applied[spec.Id] = spec
+
+		if ok {
+			log.Printf("config: restarted source '%s'", spec.Id)
+		} else {
+			log.Printf("config: attached source '%s'", spec.Id)
+		}
+	}
+
+	return nil
+}
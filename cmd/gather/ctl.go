@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mdsn/gather/lib/api"
+	"github.com/mdsn/gather/lib/ctl"
+)
+
+// `gather ctl [--socket=path] command [args...]`: send a single command to a
+// running gather, print its reply and exit non-zero if it failed.
+func runCtl(args []string) int {
+	fs := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	sockPath := fs.String("socket", ctl.DefaultPath(),
+		"control socket path, or @name for the abstract namespace (env "+ctl.EnvSocket+")")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: gather ctl [--socket=path] command [args...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	if err := oneLine(fs.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "gather: %v\n", err)
+		return 2
+	}
+	// The shell already split the words; quote them again for the socket.
+	cmd, err := api.ParseCommand(api.Join(fs.Args()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gather: %v\n", err)
+		return 2
+	}
+	words, err := absPath(fs.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gather: %v\n", err)
+		return 2
+	}
+	line := api.Join(words)
+
+	if err := send(*sockPath, line, streams(cmd), os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "gather: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// Send a command line to the gather listening on sockPath and copy its reply
+// to w. Returns the error the reply reports, if any.
+func send(sockPath, line string, streaming bool, w io.Writer) error {
+	conn, err := ctl.Dial(sockPath)
+	if err != nil {
+		return errors.New(fmt.Sprintf("is gather running? %v", err))
+	}
+	defer conn.Close()
+
+	if _, err := fmt.Fprintln(conn, line); err != nil {
+		return err
+	}
+	// A single command per connection; the server is done reading after
+	// it. A stream keeps going until gather or this end closes.
+	if err := conn.CloseWrite(); err != nil {
+		return err
+	}
+
+	return readReply(conn, w, streaming)
+}
+
+// The socket takes one command per line, so no word may span lines; quoting
+// does not help there.
+func oneLine(args []string) error {
+	for _, arg := range args {
+		if strings.ContainsAny(arg, "\r\n") {
+			return errors.New(fmt.Sprintf("argument %q spans lines", arg))
+		}
+	}
+	return nil
+}
+
+// Make the path of an `add file` or `add dir` absolute, so that gather finds
+// it whatever its own working directory is. Returns words with the path
+// replaced; they must parse as a command.
+func absPath(words []string) ([]string, error) {
+	if len(words) < 2 || words[0] != "add" || words[1] != "file" && words[1] != "dir" {
+		return words, nil
+	}
+	// Options go between the source type and the id.
+	i := 2
+	for i < len(words) && len(words[i]) > 2 && strings.HasPrefix(words[i], "--") {
+		i++
+	}
+	i++ // The id.
+	if i >= len(words) || filepath.IsAbs(words[i]) {
+		return words, nil
+	}
+
+	abs, err := filepath.Abs(words[i])
+	if err != nil {
+		return nil, err
+	}
+	words = slices.Clone(words)
+	words[i] = abs
+	return words, nil
+}
+
+// Whether the reply carries on with live output after its status line.
+func streams(cmd *api.Command) bool {
+	return cmd.Kind == api.CommandKindTail || cmd.Kind == api.CommandKindHistory && cmd.Live
+}
+
+// Copy a reply to w, leaving out its status line, and return the error it
+// reports. The status line ends a reply, unless the command streams: then
+// output follows it until the server hangs up.
+func readReply(r io.Reader, w io.Writer, streaming bool) error {
+	rd := bufio.NewReader(r)
+	var pending string
+	seenStatus := false
+
+	for {
+		line, err := rd.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		// Records of a stream cannot be mistaken for a status line, but `ls`
+		// output could be; there the status is the last line.
+		if streaming {
+			if !seenStatus && api.IsStatusLine(line) {
+				seenStatus = true
+				if status := api.ParseReply(line); status != nil {
+					return status
+				}
+				continue
+			}
+			if _, err := io.WriteString(w, line); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if pending != "" {
+			if _, err := io.WriteString(w, pending); err != nil {
+				return err
+			}
+		}
+		pending = line
+	}
+
+	if streaming {
+		if !seenStatus {
+			return errors.New("connection closed without a reply")
+		}
+		return nil
+	}
+	if pending == "" || !api.IsStatusLine(pending) {
+		return errors.New("connection closed without a reply")
+	}
+	return api.ParseReply(pending)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mdsn/gather/lib/api"
+)
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name      string
+		in        string
+		streaming bool
+		out       string
+		// The code of the error reply, if any.
+		code api.ErrorCode
+		// Whether reading fails without a reply to show for it.
+		fails bool
+	}{
+		{"ok", "ok\n", false, "", "", false},
+		{"output", "a\nb\nok\n", false, "a\nb\n", "", false},
+		{"err", "err not-found no source 'x'\n", false, "", api.ErrCodeNotFound, false},
+		{"ls status-like rows", "ok proc running\nerr file running\nok\n", false, "ok proc running\nerr file running\n", "", false},
+		{"ls err after rows", "a\nerr internal boom\n", false, "a\n", api.ErrCodeInternal, false},
+		{"no reply", "a\n", false, "", "", true},
+		{"empty", "", false, "", "", true},
+		{"stream", "ok\nx: one\nx: two\n", true, "x: one\nx: two\n", "", false},
+		{"stream status-like lines", "ok\nok\nerr x y\n", true, "ok\nerr x y\n", "", false},
+		{"stream err", "err parse bad pattern\n", true, "", api.ErrCodeParse, false},
+		{"stream no reply", "", true, "", "", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var out strings.Builder
+			err := readReply(strings.NewReader(tc.in), &out, tc.streaming)
+
+			if out.String() != tc.out {
+				t.Fatalf("unexpected output: %q", out.String())
+			}
+			var apiErr *api.Error
+			switch {
+			case tc.code != "":
+				if !errors.As(err, &apiErr) || apiErr.Code != tc.code {
+					t.Fatalf("expected %s reply, got: %v", tc.code, err)
+				}
+			case tc.fails:
+				if err == nil || errors.As(err, &apiErr) {
+					t.Fatalf("expected a read error, got: %v", err)
+				}
+			case err != nil:
+				t.Fatalf("got err: %v", err)
+			}
+		})
+	}
+}
+
+func TestOneLine(t *testing.T) {
+	if err := oneLine([]string{"add", "proc", "w", "sh", "-c", "echo 'a b'"}); err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+	for _, arg := range []string{"a\nb", "a\r", "\n"} {
+		if err := oneLine([]string{"add", "file", arg, "/tmp/x"}); err == nil {
+			t.Fatalf("expected error for %q", arg)
+		}
+	}
+}
+
+func TestAbsPath(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		in   []string
+		want []string
+	}{
+		{[]string{"add", "file", "r", "rel.log"}, []string{"add", "file", "r", filepath.Join(wd, "rel.log")}},
+		{[]string{"add", "file", "--lines=5", "--follow=name", "r", "logs/../rel.log", "--watch=poll"},
+			[]string{"add", "file", "--lines=5", "--follow=name", "r", filepath.Join(wd, "rel.log"), "--watch=poll"}},
+		{[]string{"add", "file", "app", "logs/*.log"}, []string{"add", "file", "app", filepath.Join(wd, "logs/*.log")}},
+		{[]string{"add", "dir", "--glob=*.log", "app", "."}, []string{"add", "dir", "--glob=*.log", "app", wd}},
+		{[]string{"add", "file", "abs", "/var/log/syslog"}, []string{"add", "file", "abs", "/var/log/syslog"}},
+		// Only file and dir paths are made absolute.
+		{[]string{"add", "proc", "w", "./worker", "rel.log"}, []string{"add", "proc", "w", "./worker", "rel.log"}},
+		{[]string{"rm", "rel.log"}, []string{"rm", "rel.log"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(strings.Join(tc.in, " "), func(t *testing.T) {
+			t.Parallel()
+			got, err := absPath(tc.in)
+			if err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
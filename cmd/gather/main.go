@@ -1,152 +1,333 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"golang.org/x/sys/unix"
 	"io"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/mdsn/gather/lib/api"
+	"github.com/mdsn/gather/lib/ctl"
+	"github.com/mdsn/gather/lib/format"
+	"github.com/mdsn/gather/lib/hub"
 	"github.com/mdsn/gather/lib/source"
 	"github.com/mdsn/gather/lib/source/manager"
+	"github.com/mdsn/gather/lib/source/proc"
 )
 
-const SockPath = "/tmp/gather"
-const SockBacklog = 1
+// Clients may keep their connection open, but a burst of short-lived ones
+// should not be refused.
+const SockBacklog = 64
+
+// Records buffered for each `tail` client before they start being dropped.
+const SubscriberBuffer = 1024
 
 func main() {
 	log.SetFlags(0)
 	log.SetPrefix("gather: ")
 
-	// Set up a unix domain socket for ctl
-	sfd, err := unix.Socket(unix.AF_UNIX, unix.SOCK_STREAM, 0)
-	if err != nil {
-		log.Fatalf("socket: %v", err)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "ctl":
+			os.Exit(runCtl(os.Args[2:]))
+		case "completion":
+			os.Exit(runCompletion(os.Args[2:]))
+		}
 	}
 
-	// Create the Sockaddr for the UNIX domain socket
-	addr := unix.SockaddrUnix{Name: SockPath}
+	subreaper := flag.Bool("subreaper", false,
+		"adopt and reap processes orphaned by proc sources")
+	formatName := flag.String("format", "text",
+		"output format of the aggregated stream: text, json or logfmt")
+	historyLines := flag.Int("history-lines", manager.DefaultSourceHistory.Lines,
+		"records of history kept per source, 0 to disable")
+	historyBytes := flag.Int("history-bytes", manager.DefaultSourceHistory.Bytes,
+		"bytes of history kept per source, 0 for no limit")
+	globalLines := flag.Int("history-global-lines", manager.DefaultGlobalHistory.Lines,
+		"records of history kept across all sources, 0 to disable")
+	globalBytes := flag.Int("history-global-bytes", manager.DefaultGlobalHistory.Bytes,
+		"bytes of history kept across all sources, 0 for no limit")
+	stateDir := flag.String("state-dir", "",
+		"directory to checkpoint file offsets in, to resume after a restart")
+	configPath := flag.String("config", "",
+		"file of `add` commands to attach at startup, and again on SIGHUP")
+	sockPath := flag.String("socket", ctl.DefaultPath(),
+		"control socket path, or @name for the abstract namespace (env "+ctl.EnvSocket+")")
+	sockMode := flag.String("socket-mode", "0600",
+		"octal permissions of the socket file")
+	sockOwner := flag.String("socket-owner", "",
+		"owner of the socket file, as user, user:group or :group")
+	policy := ctl.NewPolicy()
+	flag.Func("allow",
+		"grant `user:level` or `@group:level` access to the socket, where level is read, file or proc; repeatable",
+		policy.Allow)
+	flag.Parse()
 
-	// Remove existing socket file, if any, ignoring errors.
-	_ = os.Remove(SockPath)
-
-	// Bind
-	err = unix.Bind(sfd, &addr)
+	outFormat, err := format.Parse(*formatName)
 	if err != nil {
-		log.Fatalf("bind: %v", err)
+		log.Fatalf("format: %v", err)
+	}
+
+	if *subreaper {
+		if err := proc.SetSubreaper(); err != nil {
+			log.Fatalf("subreaper: %v", err)
+		}
 	}
 
-	// Listen
-	err = unix.Listen(sfd, SockBacklog)
+	perm := ctl.DefaultPerm
+	if *sockMode != "" {
+		mode, err := strconv.ParseUint(*sockMode, 8, 32)
+		if err != nil || mode > 0777 {
+			log.Fatalf("socket-mode: expected an octal mode, got '%s'", *sockMode)
+		}
+		perm.Mode = os.FileMode(mode)
+	}
+	if *sockOwner != "" {
+		if perm.Uid, perm.Gid, err = ctl.ParseOwner(*sockOwner); err != nil {
+			log.Fatalf("socket-owner: %v", err)
+		}
+	}
+
+	l, err := ctl.Listen(*sockPath, SockBacklog, perm)
 	if err != nil {
-		log.Fatalf("listen: %v", err)
+		log.Fatalf("socket: %v", err)
 	}
 
-	printInfo()
+	printInfo(*sockPath)
 
 	// Set a handler for SIGTERM, SIGINT to cancel the root context.
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
 
-	m := manager.NewManager()
+	m := manager.NewManager(
+		manager.HistoryLimits{Lines: *historyLines, Bytes: *historyBytes},
+		manager.HistoryLimits{Lines: *globalLines, Bytes: *globalBytes})
 	defer m.Close()
 
-	cmdC := make(chan *api.Command)
-	go read(sfd, cmdC)
-	go execute(ctx, cmdC, m)
-	// XXX call drain() synchronously to use it as a blocking barrier. Since
-	// read() is not context-aware it does not get canceled by the signal setup
-	// above, and the process never exits.
-	drain(ctx, m)
-}
-
-func read(sfd int, cmdC chan *api.Command) {
-	for {
-		cfd, _, err := unix.Accept(sfd)
-		if err != nil {
-			log.Printf("accept: %v", err)
-			return // XXX should signal main() to exit
+	// Reloads of the config go through execute like any other change to
+	// the sources.
+	var r *reconciler
+	var hup chan os.Signal
+	if *configPath != "" {
+		r = newReconciler(*configPath, *stateDir)
+		if err := r.reconcile(ctx, m); err != nil {
+			log.Fatalf("config: %v", err)
 		}
+		hup = make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+	}
 
-		// Wrap conn fd in a go *File
-		cf := os.NewFile(uintptr(cfd), "")
-		reader := bufio.NewReader(cf)
+	reqC := make(chan *request)
+	go execute(ctx, reqC, hup, r, m, *stateDir)
+	h := hub.NewHub()
+	go drain(ctx, m, outFormat, h)
+	srv := &server{reqC: reqC, m: m, h: h, policy: policy}
+	srv.serve(ctx, l)
 
-		// XXX make this read ctx-cancellable
-		line, err := reader.ReadString('\n')
+	ctl.Remove(*sockPath)
+}
 
-		// Reader returns an error if input did not end in \n. Ignore any
-		// partial input.
-		if err == io.EOF {
-			cf.Close()
-			continue
-		}
+// Reconcile the config again, on SIGHUP.
+func reload(ctx context.Context, r *reconciler, m *manager.Manager) {
+	log.Printf("config: reloading %s", r.path)
+	if err := r.reconcile(ctx, m); err != nil {
+		log.Printf("config: %v; keeping current sources", err)
+	}
+}
 
-		line = strings.TrimSpace(line)
-		cmd, err := api.ParseCommand(line)
-		if err != nil {
-			log.Printf("parse: %v", err)
-			cf.Close()
+// Execute commands one at a time, and reload the config with r whenever hup
+// fires. hup is nil without a config.
+func execute(ctx context.Context, reqC chan *request, hup chan os.Signal, r *reconciler, m *manager.Manager, stateDir string) {
+	for {
+		var req *request
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			reload(ctx, r, m)
 			continue
+		case req = <-reqC:
 		}
 
-		cf.Close()
-		cmdC <- cmd
-	}
-}
-
-func execute(ctx context.Context, cmdC chan *api.Command, m *manager.Manager) {
-	for cmd := range cmdC {
+		cmd := req.cmd
 		switch cmd.Kind {
 		case api.CommandKindAdd:
-			spec := makeSpec(cmd)
+			spec := makeSpec(cmd, stateDir)
 			err := m.Attach(ctx, spec)
 			if err != nil {
-				log.Printf("attach: %v", err)
+				log.Printf("%s: attach: %v", req.peer, err)
+				reply(req.conn, errorCode(err, api.ErrCodeAttach), err)
 			} else {
-				log.Printf("attached source '%s'", cmd.Id)
+				log.Printf("%s: attached source '%s'", req.peer, cmd.Id)
+				reply(req.conn, "", nil)
 			}
 		case api.CommandKindRm:
 			err := m.Remove(cmd.Id)
 			if err != nil {
-				log.Printf("remove: %v", err)
+				log.Printf("%s: remove: %v", req.peer, err)
+				reply(req.conn, errorCode(err, api.ErrCodeInternal), err)
+			} else {
+				log.Printf("%s: removed source '%s'", req.peer, cmd.Id)
+				reply(req.conn, "", nil)
+			}
+		case api.CommandKindPause:
+			err := m.Pause(cmd.Id, cmd.Pause)
+			if err != nil {
+				log.Printf("%s: pause: %v", req.peer, err)
+				reply(req.conn, errorCode(err, api.ErrCodeInternal), err)
+			} else {
+				log.Printf("%s: paused source '%s'", req.peer, cmd.Id)
+				reply(req.conn, "", nil)
+			}
+		case api.CommandKindResume:
+			err := m.Resume(cmd.Id, cmd.Resume)
+			if err != nil {
+				log.Printf("%s: resume: %v", req.peer, err)
+				reply(req.conn, errorCode(err, api.ErrCodeInternal), err)
+			} else {
+				log.Printf("%s: resumed source '%s'", req.peer, cmd.Id)
+				reply(req.conn, "", nil)
+			}
+		case api.CommandKindLs:
+			log.Printf("%s: ls", req.peer)
+			if err := list(req.conn, m); err != nil {
+				log.Printf("ls: %v", err)
+				reply(req.conn, api.ErrCodeInternal, err)
 			} else {
-				log.Printf("removed source '%s'", cmd.Id)
+				reply(req.conn, "", nil)
+			}
+		case api.CommandKindStats:
+			log.Printf("%s: stats", req.peer)
+			if err := stats(req.conn, m); err != nil {
+				log.Printf("stats: %v", err)
+				reply(req.conn, api.ErrCodeInternal, err)
+			} else {
+				reply(req.conn, "", nil)
 			}
 		default:
 			log.Fatalln("execute: unknown command kind")
 		}
+		close(req.done)
+	}
+}
+
+// Write the status line for a command back to the client. A nil err replies
+// `ok`. Errors writing the reply are logged; the client may be long gone.
+func reply(w io.Writer, code api.ErrorCode, err error) {
+	var werr error
+	if err == nil {
+		werr = api.WriteOk(w)
+	} else {
+		werr = api.WriteErr(w, code, err)
+	}
+	if werr != nil {
+		log.Printf("reply: %v", werr)
+	}
+}
+
+// Map errors from the manager to a reply code, or fall back to the given one.
+func errorCode(err error, fallback api.ErrorCode) api.ErrorCode {
+	switch {
+	case errors.Is(err, manager.ErrExists):
+		return api.ErrCodeExists
+	case errors.Is(err, manager.ErrNotFound):
+		return api.ErrCodeNotFound
+	case errors.Is(err, manager.ErrNotRunning):
+		return api.ErrCodeNotRunning
+	default:
+		return fallback
+	}
+}
+
+// Write one line per attached source to w.
+func list(w io.Writer, m *manager.Manager) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	for _, st := range m.List() {
+		target := strings.Join(append([]string{st.Path}, st.Args...), " ")
+		if st.Glob != "" {
+			target = filepath.Join(st.Path, st.Glob)
+		}
+		exit := "-"
+		if st.Exit != nil {
+			exit = st.Exit.String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			st.Id,
+			st.Kind,
+			st.State,
+			st.Restarts,
+			st.AttachedAt.Format(time.RFC3339),
+			exit,
+			target)
 	}
+	return tw.Flush()
 }
 
-func drain(ctx context.Context, m *manager.Manager) {
+// Write one line per counter to w, with its name and value.
+func stats(w io.Writer, m *manager.Manager) error {
+	st := m.Stats()
+	_, err := fmt.Fprintf(w, "sources %d\ninotify-overflows %d\n", st.Sources, st.InotifyOverflows)
+	return err
+}
+
+// Write the aggregated stream to stdout, and hand every record to the
+// subscribers.
+func drain(ctx context.Context, m *manager.Manager, f format.Format, h *hub.Hub) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case ev := <-m.Events:
-			fmt.Printf("%s: %s\n", ev.Id, string(ev.Bytes))
+			if err := f.Write(os.Stdout, &ev); err != nil {
+				log.Printf("write: %v", err)
+			}
+			h.Publish(ev)
 		}
 	}
 }
 
-func makeSpec(cmd *api.Command) *source.Spec {
+func makeSpec(cmd *api.Command, stateDir string) *source.Spec {
 	// func NewSpec() ?
 	spec := &source.Spec{
 		Id:   cmd.Id,
 		Path: cmd.Path,
 		Args: cmd.Args,
+		// File options
+		Follow:   cmd.Follow,
+		Start:    cmd.Start,
+		Fallback: cmd.Fallback,
+		Watch:    cmd.Watch,
+		// Dir options
+		Glob:     cmd.Glob,
+		MaxFiles: cmd.MaxFiles,
+		MaxDepth: cmd.MaxDepth,
+		Include:  cmd.Include,
+		Exclude:  cmd.Exclude,
+		// Proc options
+		Stderr:      cmd.Stderr,
+		Restart:     cmd.Restart,
+		StopSignal:  cmd.StopSignal,
+		StopTimeout: cmd.StopTimeout,
 	}
 
 	switch cmd.Target {
 	case api.CommandTargetFile:
 		spec.Kind = source.KindFile
+		spec.StateDir = stateDir
+	case api.CommandTargetDir:
+		spec.Kind = source.KindDir
+		spec.StateDir = stateDir
 	case api.CommandTargetProc:
 		spec.Kind = source.KindProc
 	default:
@@ -156,9 +337,9 @@ func makeSpec(cmd *api.Command) *source.Spec {
 	return spec
 }
 
-func printInfo() {
+func printInfo(sockPath string) {
 	log.Printf("pid %d", os.Getpid())
 	cwd, _ := os.Getwd()
 	log.Printf("cwd %s", cwd)
-	log.Printf("socket at %s", SockPath)
+	log.Printf("socket at %s", sockPath)
 }
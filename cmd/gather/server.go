@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mdsn/gather/lib/api"
+	"github.com/mdsn/gather/lib/ctl"
+	"github.com/mdsn/gather/lib/hub"
+	"github.com/mdsn/gather/lib/source/manager"
+	"golang.org/x/sys/unix"
+)
+
+// A parsed command along with the client connection it arrived on. The
+// executing side replies on the connection and closes done; the connection
+// itself belongs to the client goroutine.
+type request struct {
+	cmd  *api.Command
+	peer ctl.Peer
+	conn net.Conn
+	done chan struct{}
+}
+
+// Everything client goroutines need to answer commands.
+type server struct {
+	// Commands that change sources are executed one at a time through here,
+	// in turn with config reloads.
+	reqC   chan *request
+	m      *manager.Manager
+	h      *hub.Hub
+	policy *ctl.Policy
+}
+
+// Accept clients until ctx is cancelled, serving each one in its own
+// goroutine. Returns once every client goroutine is done.
+func (s *server) serve(ctx context.Context, l net.Listener) {
+	// Closing the listener is the only way to unblock Accept.
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer stop()
+
+	var wg sync.WaitGroup
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("accept: %v", err)
+			}
+			break
+		}
+		wg.Go(func() { s.client(ctx, conn) })
+	}
+	wg.Wait()
+}
+
+// Read newline-delimited commands from conn until the client closes its end,
+// answering each one before reading the next. A `tail`, or a `history` that
+// carries on with live output, takes over the connection for good.
+func (s *server) client(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	// Unblock a pending read on shutdown.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	peer, err := ctl.PeerCred(conn)
+	if err != nil {
+		log.Printf("peer: %v", err)
+		return
+	}
+	access := s.policy.Access(peer)
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			// Reader returns an error if input did not end in \n. Reject any
+			// partial input.
+			if errors.Is(err, io.EOF) && len(line) > 0 {
+				reply(conn, api.ErrCodeParse, errors.New("command not terminated by newline"))
+			}
+			return
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		cmd, err := api.ParseCommand(line)
+		if err != nil {
+			log.Printf("parse: %v", err)
+			reply(conn, api.ErrCodeParse, err)
+			continue
+		}
+
+		if need := required(cmd, s.m); access < need {
+			err := errors.New(fmt.Sprintf("'%s' needs %s access, uid %d has %s", line, need, peer.Uid, access))
+			log.Printf("denied: %s: %v", peer, err)
+			reply(conn, api.ErrCodeDenied, err)
+			continue
+		}
+
+		switch {
+		case cmd.Kind == api.CommandKindTail:
+			log.Printf("%s: tail %q", peer, cmd.Patterns)
+			subscribe(hangup(ctx, conn, reader), conn, s.h, cmd)
+			return
+		case cmd.Kind == api.CommandKindHistory && cmd.Live:
+			log.Printf("%s: history %q", peer, cmd.Patterns)
+			// Subscribe before taking the snapshot so nothing falls in
+			// between; follow skips what was already replayed.
+			sub := s.h.Subscribe(cmd.Patterns, SubscriberBuffer)
+			replayed := replay(conn, s.m, cmd)
+			follow(hangup(ctx, conn, reader), conn, s.h, sub, cmd, replayed)
+			return
+		case cmd.Kind == api.CommandKindHistory:
+			log.Printf("%s: history %q", peer, cmd.Patterns)
+			replay(conn, s.m, cmd)
+			reply(conn, "", nil)
+			continue
+		}
+
+		req := &request{cmd: cmd, peer: peer, conn: conn, done: make(chan struct{})}
+		select {
+		case s.reqC <- req:
+		case <-ctx.Done():
+			return
+		}
+
+		select {
+		case <-req.done:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+var errHangup = errors.New("client closed the connection")
+
+// How often a client that is done sending is checked for having gone away.
+var hangupInterval = 500 * time.Millisecond
+
+// A context that is cancelled along with ctx, or once the client closes
+// conn. Anything else it sends, read through r, is discarded. Without it, a
+// client that goes away is only noticed on the next write.
+func hangup(ctx context.Context, conn net.Conn, r io.Reader) context.Context {
+	ctx, cancel := context.WithCancelCause(ctx)
+	go func() {
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			cancel(err)
+			return
+		}
+		// The end of input only means the client is done sending, as with
+		// `gather ctl` or socat shutting down their writing end; it may
+		// still be reading.
+		cancel(awaitHangup(ctx, conn))
+	}()
+	return ctx
+}
+
+// Wait for the peer of conn to close it for good, or for ctx to be done.
+// Unlike a shutdown of its writing end, that raises POLLHUP on the socket.
+func awaitHangup(ctx context.Context, conn net.Conn) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(hangupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		var hup bool
+		err := rc.Control(func(fd uintptr) {
+			fds := []unix.PollFd{{Fd: int32(fd)}}
+			if n, err := unix.Poll(fds, 0); err == nil && n > 0 {
+				hup = fds[0].Revents&(unix.POLLHUP|unix.POLLERR|unix.POLLNVAL) != 0
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if hup {
+			return errHangup
+		}
+	}
+}
+
+// Stream records matching the command's patterns to conn, until the client
+// goes away or ctx is cancelled.
+func subscribe(ctx context.Context, conn net.Conn, h *hub.Hub, cmd *api.Command) {
+	sub := h.Subscribe(cmd.Patterns, SubscriberBuffer)
+	follow(ctx, conn, h, sub, cmd, nil)
+}
+
+// Reply ok and stream the records delivered to sub. Records captured no later
+// than the last one replayed from the same source were already written, and
+// are skipped.
+func follow(ctx context.Context, conn net.Conn, h *hub.Hub, sub *hub.Subscription, cmd *api.Command, replayed map[string]time.Time) {
+	defer h.Unsubscribe(sub)
+
+	reply(conn, "", nil)
+
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			if err := context.Cause(ctx); err != context.Canceled {
+				log.Printf("unsubscribed: %v", err)
+			}
+			return
+		case msg := <-sub.C:
+			switch {
+			case msg.Dropped > 0:
+				err = cmd.Format.WriteDropped(conn, msg.Dropped, time.Now())
+			case !msg.Out.CapturedAt.After(replayed[msg.Out.Id]):
+				// Seen in the replay.
+			default:
+				err = cmd.Format.Write(conn, &msg.Out)
+			}
+		}
+		if err != nil {
+			log.Printf("unsubscribed: %v", err)
+			return
+		}
+	}
+}
+
+// Write the recent records selected by the command to conn. Returns the
+// capture time of the last record written from each source.
+func replay(conn net.Conn, m *manager.Manager, cmd *api.Command) map[string]time.Time {
+	q := manager.HistoryQuery{
+		Patterns: cmd.Patterns,
+		Lines:    cmd.Lines,
+	}
+	if cmd.Since > 0 {
+		q.Since = time.Now().Add(-cmd.Since)
+	}
+
+	last := make(map[string]time.Time)
+	for _, out := range m.History(q) {
+		if err := cmd.Format.Write(conn, &out); err != nil {
+			log.Printf("history: %v", err)
+			break
+		}
+		last[out.Id] = out.CapturedAt
+	}
+	return last
+}
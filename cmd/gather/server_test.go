@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mdsn/gather/lib/ctl"
+	"github.com/mdsn/gather/lib/hub"
+	"github.com/mdsn/gather/lib/source"
+	"github.com/mdsn/gather/lib/source/manager"
+)
+
+// Both ends of a connection over a UNIX socket.
+func socketPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	t.Helper()
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "sock"))
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+
+	client, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+	return server.(*net.UnixConn), client.(*net.UnixConn)
+}
+
+func TestHangup(t *testing.T) {
+	server, client := socketPair(t)
+	defer server.Close()
+
+	ctx := hangup(t.Context(), server, server)
+
+	// Whatever the client sends after the command is of no consequence, and
+	// neither is it being done sending.
+	if _, err := client.Write([]byte("ls\n")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := client.CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite: %v", err)
+	}
+	select {
+	case <-ctx.Done():
+		t.Fatalf("cancelled while the client is still there: %v", context.Cause(ctx))
+	case <-time.After(3 * hangupInterval):
+	}
+
+	client.Close()
+	select {
+	case <-ctx.Done():
+		if err := context.Cause(ctx); !errors.Is(err, errHangup) {
+			t.Fatalf("unexpected cause: %v", err)
+		}
+	case <-time.After(3 * hangupInterval):
+		t.Fatal("timeout waiting for the hangup")
+	}
+}
+
+// `gather ctl tail` against a running server.
+func TestCtlTail(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "gather")
+	l, err := ctl.Listen(path, SockBacklog, ctl.DefaultPerm)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	m := manager.NewManager(manager.DefaultSourceHistory, manager.DefaultGlobalHistory)
+	defer m.Close()
+	h := hub.NewHub()
+	srv := &server{reqC: make(chan *request), m: m, h: h, policy: ctl.NewPolicy()}
+	served := make(chan struct{})
+	go func() {
+		srv.serve(ctx, l)
+		close(served)
+	}()
+
+	r, w := io.Pipe()
+	sent := make(chan error, 1)
+	go func() {
+		sent <- send(path, "tail 'work*'", true, w)
+		w.Close()
+	}()
+
+	// The reply carries no sign of the subscription being in place; publish
+	// until something comes through.
+	published := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-published:
+				return
+			case <-ticker.C:
+				h.Publish(source.Output{Id: "other", CapturedAt: time.Now(), Bytes: []byte("skipped")})
+				h.Publish(source.Output{Id: "worker", CapturedAt: time.Now(), Bytes: []byte("hello")})
+			}
+		}
+	}()
+
+	line, err := bufio.NewReader(r).ReadString('\n')
+	close(published)
+	if err != nil {
+		t.Fatalf("ReadString: %v", err)
+	}
+	if !strings.Contains(line, "worker") || !strings.Contains(line, "hello") {
+		t.Fatalf("unexpected line: %q", line)
+	}
+	go io.Copy(io.Discard, r)
+
+	// Gather going away ends the stream without an error.
+	cancel()
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the stream to end")
+	}
+	<-served
+}
@@ -3,9 +3,14 @@ package api
 import (
 	"errors"
 	"fmt"
-	"slices"
+	"path"
 	"strings"
+	"syscall"
 	"time"
+	"unicode"
+
+	"github.com/mdsn/gather/lib/format"
+	"github.com/mdsn/gather/lib/source"
 )
 
 type CommandKind uint8
@@ -13,6 +18,12 @@ type CommandKind uint8
 const (
 	CommandKindAdd CommandKind = iota
 	CommandKindRm
+	CommandKindLs
+	CommandKindTail
+	CommandKindHistory
+	CommandKindPause
+	CommandKindResume
+	CommandKindStats
 )
 
 type CommandTarget uint8
@@ -21,6 +32,7 @@ const (
 	CommandTargetUnknown = iota
 	CommandTargetFile
 	CommandTargetProc
+	CommandTargetDir
 )
 
 type Command struct {
@@ -29,11 +41,41 @@ type Command struct {
 	Id     string
 	Path   string
 	Args   []string
+	// File target options
+	Follow   source.FollowMode
+	Start    source.Start
+	Fallback source.Fallback
+	Watch    source.WatchMode
+	// Dir target options
+	Glob     string
+	MaxFiles int
+	MaxDepth int
+	Include  []string
+	Exclude  []string
+	// Proc target options
+	Stderr      source.StderrMode
+	Restart     source.Restart
+	StopSignal  syscall.Signal
+	StopTimeout time.Duration
+	// Tail and history options. Patterns are globs matched against source
+	// ids.
+	Patterns []string
+	Format   format.Format
+	// History options
+	Lines int
+	Since time.Duration
+	Live  bool
+	// Pause and resume options
+	Pause  source.Pause
+	Resume source.Resume
 	sentAt time.Time
 }
 
 func ParseCommand(in string) (*Command, error) {
-	toks := tokens(in)
+	toks, err := tokens(in)
+	if err != nil {
+		return nil, err
+	}
 	if len(toks) == 0 {
 		return nil, errors.New("empty input")
 	}
@@ -45,42 +87,113 @@ func ParseCommand(in string) (*Command, error) {
 	case "rm":
 		return parseRm(toks[1:])
 
+	case "ls":
+		return parseLs(toks[1:])
+
+	case "stats":
+		return parseStats(toks[1:])
+
+	case "tail", "subscribe":
+		return parseTail(toks[1:])
+
+	case "history":
+		return parseHistory(toks[1:])
+
+	case "pause":
+		return parseSourceCommand(CommandKindPause, "pause", pauseOptions, toks[1:])
+
+	case "resume":
+		return parseSourceCommand(CommandKindResume, "resume", resumeOptions, toks[1:])
+
 	default:
-		return nil, errors.New(fmt.Sprintf("unknown command '%s", toks[0]))
+		return nil, errors.New(fmt.Sprintf("unknown command '%s'", toks[0]))
 	}
-
-	return nil, nil
 }
 
 func parseAdd(toks []string) (*Command, error) {
-	if len(toks) < 3 {
+	if len(toks) < 1 {
 		return nil, errors.New("missing arguments to 'add'")
 	}
 
 	cmd := &Command{
 		Kind:   CommandKindAdd,
-		Id:     toks[1],
-		Path:   toks[2],
 		sentAt: time.Now(),
 	}
 
+	var options map[string]optionFunc
 	switch toks[0] {
 	case "file":
 		cmd.Target = CommandTargetFile
-		return cmd, nil
+		options = fileOptions
 	case "proc":
 		cmd.Target = CommandTargetProc
-		cmd.Args = toks[3:]
-		return cmd, nil
+		options = procOptions
+	case "dir":
+		cmd.Target = CommandTargetDir
+		options = dirOptions
 	default:
 		return nil, errors.New(fmt.Sprintf("add: unknown source type '%s'", toks[0]))
 	}
+
+	// Options go between the source type and the id.
+	rest := toks[1:]
+	for len(rest) > 0 && isOption(rest[0]) {
+		if err := parseOption(cmd, "add", options, rest[0]); err != nil {
+			return nil, err
+		}
+		rest = rest[1:]
+	}
+
+	if len(rest) < 2 {
+		return nil, errors.New("missing arguments to 'add'")
+	}
+
+	// An `ls` row starts with the id, and must not read as a status line.
+	if rest[0] == ReplyOk || rest[0] == ReplyErr {
+		return nil, errors.New(fmt.Sprintf("add: '%s' is reserved and cannot be an id", rest[0]))
+	}
+	if strings.ContainsFunc(rest[0], unicode.IsSpace) {
+		return nil, errors.New(fmt.Sprintf("add: id '%s' contains blanks", rest[0]))
+	}
+
+	cmd.Id = rest[0]
+	cmd.Path = rest[1]
+
+	switch cmd.Target {
+	case CommandTargetFile, CommandTargetDir:
+		// A file takes no arguments, so options may also follow the path.
+		for _, tok := range rest[2:] {
+			if !isOption(tok) {
+				return nil, errors.New(fmt.Sprintf("add: unexpected argument '%s'", tok))
+			}
+			if err := parseOption(cmd, "add", options, tok); err != nil {
+				return nil, err
+			}
+		}
+	case CommandTargetProc:
+		cmd.Args = rest[2:]
+	}
+
+	// A glob in the file name stands for the files matching it, unless a
+	// file by that very name exists when the source is attached.
+	if cmd.Target == CommandTargetFile && cmd.MaxFiles != 0 && !isGlob(cmd.Path) {
+		return nil, errors.New("add: --max-files needs a glob in the path")
+	}
+
+	return cmd, nil
+}
+
+func isGlob(p string) bool {
+	return strings.ContainsAny(p, `*?[`)
 }
 
 func parseRm(toks []string) (*Command, error) {
 	if len(toks) < 1 {
 		return nil, errors.New("missing argument to 'rm'")
 	}
+	if len(toks) > 1 {
+		return nil, errors.New(fmt.Sprintf("rm: unexpected argument '%s'", toks[1]))
+	}
 
 	cmd := &Command{
 		Kind:   CommandKindRm,
@@ -91,9 +204,119 @@ func parseRm(toks []string) (*Command, error) {
 	return cmd, nil
 }
 
-func tokens(in string) []string {
-	parts := strings.Split(in, " ")
-	return slices.DeleteFunc(parts, func(s string) bool {
-		return len(s) == 0
-	})
+// A command on a single source, taking its id and options in any order.
+func parseSourceCommand(kind CommandKind, command string, options map[string]optionFunc, toks []string) (*Command, error) {
+	cmd := &Command{
+		Kind:   kind,
+		sentAt: time.Now(),
+	}
+
+	for _, tok := range toks {
+		if isOption(tok) {
+			if err := parseOption(cmd, command, options, tok); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if cmd.Id != "" {
+			return nil, errors.New(fmt.Sprintf("%s: unexpected argument '%s'", command, tok))
+		}
+		cmd.Id = tok
+	}
+
+	if cmd.Id == "" {
+		return nil, errors.New(fmt.Sprintf("missing argument to '%s'", command))
+	}
+	return cmd, nil
+}
+
+func parseLs(toks []string) (*Command, error) {
+	if len(toks) > 0 {
+		return nil, errors.New(fmt.Sprintf("ls: unexpected argument '%s'", toks[0]))
+	}
+
+	cmd := &Command{
+		Kind:   CommandKindLs,
+		sentAt: time.Now(),
+	}
+
+	return cmd, nil
+}
+
+func parseStats(toks []string) (*Command, error) {
+	if len(toks) > 0 {
+		return nil, errors.New(fmt.Sprintf("stats: unexpected argument '%s'", toks[0]))
+	}
+
+	cmd := &Command{
+		Kind:   CommandKindStats,
+		sentAt: time.Now(),
+	}
+
+	return cmd, nil
+}
+
+func parseTail(toks []string) (*Command, error) {
+	cmd := &Command{
+		Kind:   CommandKindTail,
+		sentAt: time.Now(),
+	}
+
+	for _, tok := range toks {
+		if isOption(tok) {
+			if err := parseOption(cmd, "tail", tailOptions, tok); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := addPattern(cmd, "tail", tok); err != nil {
+			return nil, err
+		}
+	}
+
+	return cmd, nil
+}
+
+func parseHistory(toks []string) (*Command, error) {
+	cmd := &Command{
+		Kind:   CommandKindHistory,
+		sentAt: time.Now(),
+	}
+
+	for i := 0; i < len(toks); i++ {
+		tok := toks[i]
+		switch {
+		// These also take their value as the next word.
+		case tok == "-n" || tok == "--since":
+			if i+1 == len(toks) {
+				return nil, errors.New(fmt.Sprintf("history: missing value for '%s'", tok))
+			}
+			name := "since"
+			if tok == "-n" {
+				name = "lines"
+			}
+			i++
+			if err := parseOption(cmd, "history", historyOptions, "--"+name+"="+toks[i]); err != nil {
+				return nil, err
+			}
+		case isOption(tok):
+			if err := parseOption(cmd, "history", historyOptions, tok); err != nil {
+				return nil, err
+			}
+		default:
+			if err := addPattern(cmd, "history", tok); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return cmd, nil
+}
+
+func addPattern(cmd *Command, command string, pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return errors.New(fmt.Sprintf("%s: bad pattern '%s'", command, pattern))
+	}
+	cmd.Patterns = append(cmd.Patterns, pattern)
+	return nil
 }
@@ -1,7 +1,14 @@
 package api
 
 import (
+	"errors"
+	"slices"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/mdsn/gather/lib/format"
+	"github.com/mdsn/gather/lib/source"
 )
 
 func TestParseCommand_ErrorScenarios(t *testing.T) {
@@ -13,8 +20,28 @@ func TestParseCommand_ErrorScenarios(t *testing.T) {
 		"add proc myWorker",
 		"add file",
 		"add file myFile",
+		"add file ok /var/log/syslog",
+		"add proc err ./worker",
+		"add dir ok /var/log/app",
+		"add file 'err x' /var/log/syslog",
+		"add file 'ok' /var/log/syslog",
+		"add proc 'a\tb' ./worker",
+		"add file f /a/b extra",
+		"add file f /a/b --lines=5 extra",
+		"add dir d /a extra",
 		"add rhubarb mySalad",
 		"rm",
+		"rm a b",
+		"rm myWorker ignored-arg onetwothree",
+		"ls ignored-arg",
+		"stats extra",
+		"tail --format=yaml",
+		"tail --lines=10",
+		"tail work[",
+		"history -n",
+		"history -n 0",
+		"history --since=soon",
+		"history --follow=yes",
 	}
 
 	for _, tc := range tests {
@@ -120,7 +147,7 @@ func TestParseCommand_Rm(t *testing.T) {
 		name string
 		in   string
 	}{
-		{"with-args", "rm myWorker ignored-arg onetwothree"},
+		{"extra-spaces", "  rm   myWorker "},
 		{"no-args", "rm myWorker"},
 	}
 
@@ -163,3 +190,650 @@ func TestParseCommand_Rm(t *testing.T) {
 	}
 
 }
+
+func TestParseCommand_Ls(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"no-args", "ls"},
+		{"extra-spaces", "  ls  "},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cmd, err := ParseCommand(tc.in)
+			if err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+
+			if cmd == nil {
+				t.Fatal("nil cmd")
+			}
+
+			if cmd.Kind != CommandKindLs {
+				t.Fatal("wrong command kind")
+			}
+
+			if len(cmd.Id) != 0 {
+				t.Fatal("unexpected command id:", cmd.Id)
+			}
+
+			if cmd.sentAt.IsZero() {
+				t.Fatal("sentAt not initialized")
+			}
+		})
+	}
+}
+
+func TestParseCommand_Stats(t *testing.T) {
+	cmd, err := ParseCommand("stats")
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+	if cmd.Kind != CommandKindStats {
+		t.Fatal("wrong command kind")
+	}
+}
+
+func TestParseCommand_AddFileFollow(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		follow source.FollowMode
+	}{
+		{"default", "add file myFile /var/log/syslog", source.FollowDescriptor},
+		{"before-id", "add file --follow=name myFile /var/log/syslog", source.FollowName},
+		{"after-path", "add file myFile /var/log/syslog --follow=name", source.FollowName},
+		{"descriptor", "add file --follow=descriptor myFile /var/log/syslog", source.FollowDescriptor},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cmd, err := ParseCommand(tc.in)
+			if err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+
+			if cmd.Id != "myFile" {
+				t.Fatal("wrong command id:", cmd.Id)
+			}
+
+			if cmd.Path != "/var/log/syslog" {
+				t.Fatal("wrong command path:", cmd.Path)
+			}
+
+			if cmd.Follow != tc.follow {
+				t.Fatal("wrong follow mode:", cmd.Follow)
+			}
+		})
+	}
+}
+
+func TestParseCommand_AddFileStart(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		start source.Start
+	}{
+		{"default", "add file myFile /var/log/syslog", source.Start{}},
+		{"end", "add file --from=end myFile /var/log/syslog", source.Start{Mode: source.StartEnd}},
+		{"start", "add file --from=start myFile /var/log/syslog", source.Start{Mode: source.StartBeginning}},
+		{"offset", "add file --from=offset:1024 myFile /var/log/syslog", source.Start{Mode: source.StartOffset, Offset: 1024}},
+		{"lines", "add file myFile /var/log/syslog --lines=20", source.Start{Mode: source.StartLines, Lines: 20}},
+		{"end-lines", "add file --from=end --lines=20 myFile /var/log/syslog", source.Start{Mode: source.StartLines, Lines: 20}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cmd, err := ParseCommand(tc.in)
+			if err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+
+			if cmd.Start != tc.start {
+				t.Fatalf("wrong start: %+v", cmd.Start)
+			}
+		})
+	}
+}
+
+func TestParseCommand_AddFileFallback(t *testing.T) {
+	tests := []struct {
+		in       string
+		fallback source.Fallback
+	}{
+		{"add file myFile /var/log/syslog", source.FallbackFrom},
+		{"add file --fallback=from myFile /var/log/syslog", source.FallbackFrom},
+		{"add file --fallback=start myFile /var/log/syslog", source.FallbackBeginning},
+		{"add file myFile /var/log/syslog --fallback=end", source.FallbackEnd},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			t.Parallel()
+			cmd, err := ParseCommand(tc.in)
+			if err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+			if cmd.Fallback != tc.fallback {
+				t.Fatal("wrong fallback:", cmd.Fallback)
+			}
+		})
+	}
+}
+
+func TestParseCommand_AddFileWatch(t *testing.T) {
+	tests := []struct {
+		in    string
+		watch source.WatchMode
+	}{
+		{"add file myFile /mnt/nfs/app.log", source.WatchAuto},
+		{"add file --watch=auto myFile /mnt/nfs/app.log", source.WatchAuto},
+		{"add file --watch=inotify myFile /mnt/nfs/app.log", source.WatchInotify},
+		{"add file myFile /mnt/nfs/app.log --watch=poll", source.WatchPoll},
+		{"add dir --watch=poll app /mnt/nfs/app", source.WatchPoll},
+		{"add file --watch=poll app '/mnt/nfs/app/*.log'", source.WatchPoll},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			t.Parallel()
+			cmd, err := ParseCommand(tc.in)
+			if err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+			if cmd.Watch != tc.watch {
+				t.Fatal("wrong watch:", cmd.Watch)
+			}
+		})
+	}
+}
+
+func TestParseCommand_AddOptionErrors(t *testing.T) {
+	tests := []string{
+		"add file --follow=inode myFile /var/log/syslog",
+		"add file --follow myFile /var/log/syslog",
+		"add file --rhubarb=yes myFile /var/log/syslog",
+		"add file --follow=name myFile",
+		"add proc --follow=name myWorker ./worker",
+		"add proc --stderr=drop myWorker ./worker",
+		"add file --stderr=tag myFile /var/log/syslog",
+		"add file --from=middle myFile /var/log/syslog",
+		"add file --watch=fanotify myFile /var/log/syslog",
+		"add proc --watch=poll myWorker ./worker",
+		"add file --from=offset:-1 myFile /var/log/syslog",
+		"add file --from=offset: myFile /var/log/syslog",
+		"add file --lines=0 myFile /var/log/syslog",
+		"add file --from=start --lines=10 myFile /var/log/syslog",
+		"add file --lines=10 --from=offset:5 myFile /var/log/syslog",
+		"add proc --lines=10 myWorker ./worker",
+		"add file --fallback=middle myFile /var/log/syslog",
+		"add proc --fallback=start myWorker ./worker",
+		"add proc --restart=sometimes myWorker ./worker",
+		"add proc --restart-backoff=soon myWorker ./worker",
+		"add proc --restart-backoff=-1s myWorker ./worker",
+		"add proc --restart-max=-1 myWorker ./worker",
+		"add proc --stop-signal=SIGNOPE myWorker ./worker",
+		"add proc --stop-signal=0 myWorker ./worker",
+		"add proc --stop-timeout=0s myWorker ./worker",
+	}
+
+	for _, tc := range tests {
+		t.Run(tc, func(t *testing.T) {
+			t.Parallel()
+			cmd, err := ParseCommand(tc)
+			if cmd != nil {
+				t.Fatalf("expected nil command, got: %v", cmd)
+			}
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestParseCommand_AddProcOptionLikeArgs(t *testing.T) {
+	// Options after the command belong to the command.
+	cmd, err := ParseCommand("add proc myWorker ./worker --follow=name")
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	if len(cmd.Args) != 1 || cmd.Args[0] != "--follow=name" {
+		t.Fatal("wrong args:", cmd.Args)
+	}
+}
+
+func TestParseCommand_AddProcStderr(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		stderr source.StderrMode
+	}{
+		{"default", "add proc myWorker ./worker", source.StderrMerge},
+		{"merge", "add proc --stderr=merge myWorker ./worker", source.StderrMerge},
+		{"tag", "add proc --stderr=tag myWorker ./worker -v", source.StderrTag},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cmd, err := ParseCommand(tc.in)
+			if err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+
+			if cmd.Path != "./worker" {
+				t.Fatal("wrong command path:", cmd.Path)
+			}
+
+			if cmd.Stderr != tc.stderr {
+				t.Fatal("wrong stderr mode:", cmd.Stderr)
+			}
+		})
+	}
+}
+
+func TestParseCommand_AddProcRestart(t *testing.T) {
+	cmd, err := ParseCommand("add proc --restart=on-failure --restart-backoff=500ms " +
+		"--restart-max-backoff=30s --restart-max=5 --restart-window=10m myWorker ./worker")
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	want := source.Restart{
+		Mode:        source.RestartOnFailure,
+		Backoff:     500 * time.Millisecond,
+		MaxBackoff:  30 * time.Second,
+		MaxRestarts: 5,
+		Window:      10 * time.Minute,
+	}
+	if cmd.Restart != want {
+		t.Fatalf("wrong restart policy: %+v", cmd.Restart)
+	}
+}
+
+func TestParseCommand_AddProcStop(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		sig  syscall.Signal
+	}{
+		{"name", "add proc --stop-signal=TERM myWorker ./worker", syscall.SIGTERM},
+		{"prefixed", "add proc --stop-signal=SIGINT myWorker ./worker", syscall.SIGINT},
+		{"lowercase", "add proc --stop-signal=hup myWorker ./worker", syscall.SIGHUP},
+		{"number", "add proc --stop-signal=15 myWorker ./worker", syscall.SIGTERM},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cmd, err := ParseCommand(tc.in)
+			if err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+
+			if cmd.StopSignal != tc.sig {
+				t.Fatal("wrong stop signal:", cmd.StopSignal)
+			}
+		})
+	}
+
+	cmd, err := ParseCommand("add proc --stop-timeout=10s myWorker ./worker")
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	if cmd.StopTimeout != 10*time.Second {
+		t.Fatal("wrong stop timeout:", cmd.StopTimeout)
+	}
+}
+
+func TestTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{"blanks", "  add\tproc  w  ", []string{"add", "proc", "w"}},
+		{"single-quotes", `sh -c 'echo one; echo "two"'`, []string{"sh", "-c", `echo one; echo "two"`}},
+		{"double-quotes", `sh -c "echo one; echo 'two'"`, []string{"sh", "-c", "echo one; echo 'two'"}},
+		{"double-quote-escapes", `"a \"b\" \\ \$ \x"`, []string{`a "b" \ $ \x`}},
+		{"backslash", `/var/log/my\ app.log`, []string{"/var/log/my app.log"}},
+		{"backslash-quote", `it\'s`, []string{"it's"}},
+		{"adjacent", `--glob='*.log'x"y"`, []string{"--glob=*.logxy"}},
+		{"empty-quotes", `echo '' ""`, []string{"echo", "", ""}},
+		{"single-quote-backslash", `'a\b'`, []string{`a\b`}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			toks, err := tokens(tc.in)
+			if err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+			if !slices.Equal(toks, tc.want) {
+				t.Fatalf("got %q, want %q", toks, tc.want)
+			}
+		})
+	}
+}
+
+func TestJoin_RoundTrip(t *testing.T) {
+	tests := [][]string{
+		{"add", "proc", "w", "sh", "-c", "echo one; echo two"},
+		{"add", "file", "f", "/var/log/my app.log"},
+		{"it's", `say "hi"`, `back\slash`, "", "tab\there"},
+		{"--glob=*.log", "$HOME", "`cmd`"},
+	}
+
+	for _, words := range tests {
+		t.Run(Join(words), func(t *testing.T) {
+			t.Parallel()
+			toks, err := tokens(Join(words))
+			if err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+			if !slices.Equal(toks, words) {
+				t.Fatalf("got %q, want %q", toks, words)
+			}
+		})
+	}
+}
+
+func TestTokens_SyntaxErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		col  int
+	}{
+		{"single-quote", `add proc w sh -c 'echo`, 18},
+		{"double-quote", `add proc w sh -c "echo \"`, 18},
+		{"trailing-backslash", `add file f /tmp/x\`, 18},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cmd, err := ParseCommand(tc.in)
+			if cmd != nil {
+				t.Fatalf("expected nil command, got: %v", cmd)
+			}
+
+			var serr *SyntaxError
+			if !errors.As(err, &serr) {
+				t.Fatalf("expected *SyntaxError, got: %v", err)
+			}
+			if serr.Col != tc.col {
+				t.Fatalf("wrong column %d, want %d", serr.Col, tc.col)
+			}
+		})
+	}
+}
+
+func TestParseCommand_AddProcQuoted(t *testing.T) {
+	cmd, err := ParseCommand(`add proc sub sh -c "echo one; echo two; echo -n three"`)
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	if cmd.Path != "sh" {
+		t.Fatal("wrong command path:", cmd.Path)
+	}
+
+	want := []string{"-c", "echo one; echo two; echo -n three"}
+	if !slices.Equal(cmd.Args, want) {
+		t.Fatalf("wrong args: %q", cmd.Args)
+	}
+}
+
+func TestParseCommand_Tail(t *testing.T) {
+	tests := []struct {
+		in       string
+		patterns []string
+		format   format.Format
+	}{
+		{"tail", nil, format.FormatText},
+		{"subscribe", nil, format.FormatText},
+		{"tail syslog", []string{"syslog"}, format.FormatText},
+		{"tail --format=json syslog 'work*'", []string{"syslog", "work*"}, format.FormatJSON},
+		{"subscribe app/* --format=logfmt", []string{"app/*"}, format.FormatLogfmt},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			t.Parallel()
+			cmd, err := ParseCommand(tc.in)
+			if err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+			if cmd.Kind != CommandKindTail {
+				t.Fatal("wrong command kind")
+			}
+			if !slices.Equal(cmd.Patterns, tc.patterns) {
+				t.Fatalf("wrong patterns: %q", cmd.Patterns)
+			}
+			if cmd.Format != tc.format {
+				t.Fatal("wrong format:", cmd.Format)
+			}
+		})
+	}
+}
+
+func TestParseCommand_History(t *testing.T) {
+	tests := []struct {
+		in       string
+		patterns []string
+		lines    int
+		since    time.Duration
+		live     bool
+	}{
+		{"history", nil, 0, 0, false},
+		{"history worker", []string{"worker"}, 0, 0, false},
+		{"history worker -n 50", []string{"worker"}, 50, 0, false},
+		{"history worker --lines=50 --since 5m", []string{"worker"}, 50, 5 * time.Minute, false},
+		{"history --since=1h30m --follow 'app/*'", []string{"app/*"}, 0, 90 * time.Minute, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			t.Parallel()
+			cmd, err := ParseCommand(tc.in)
+			if err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+			if cmd.Kind != CommandKindHistory {
+				t.Fatal("wrong command kind")
+			}
+			if !slices.Equal(cmd.Patterns, tc.patterns) {
+				t.Fatalf("wrong patterns: %q", cmd.Patterns)
+			}
+			if cmd.Lines != tc.lines {
+				t.Fatal("wrong lines:", cmd.Lines)
+			}
+			if cmd.Since != tc.since {
+				t.Fatal("wrong since:", cmd.Since)
+			}
+			if cmd.Live != tc.live {
+				t.Fatal("wrong follow:", cmd.Live)
+			}
+		})
+	}
+}
+
+func TestParseCommand_PauseResume(t *testing.T) {
+	tests := []struct {
+		in     string
+		kind   CommandKind
+		id     string
+		pause  source.Pause
+		resume source.Resume
+	}{
+		{"pause worker", CommandKindPause, "worker", source.Pause{}, source.Resume{}},
+		{"pause --discard worker", CommandKindPause, "worker", source.Pause{Discard: true}, source.Resume{}},
+		{"pause worker --buffer=50", CommandKindPause, "worker", source.Pause{Buffer: 50}, source.Resume{}},
+		{"resume 'app log'", CommandKindResume, "app log", source.Pause{}, source.Resume{}},
+		{"resume --skip syslog", CommandKindResume, "syslog", source.Pause{}, source.Resume{Skip: true}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			t.Parallel()
+			cmd, err := ParseCommand(tc.in)
+			if err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+			if cmd.Kind != tc.kind {
+				t.Fatal("wrong command kind")
+			}
+			if cmd.Id != tc.id {
+				t.Fatalf("wrong id: %q", cmd.Id)
+			}
+			if cmd.Pause != tc.pause {
+				t.Fatalf("wrong pause: %+v", cmd.Pause)
+			}
+			if cmd.Resume != tc.resume {
+				t.Fatalf("wrong resume: %+v", cmd.Resume)
+			}
+		})
+	}
+}
+
+func TestParseCommand_PauseResumeErrors(t *testing.T) {
+	tests := []string{
+		"pause",
+		"resume",
+		"pause a b",
+		"pause --buffer=0 worker",
+		"pause --discard --buffer=10 worker",
+		"pause --skip worker",
+		"resume --skip=yes worker",
+		"resume --discard worker",
+	}
+
+	for _, tc := range tests {
+		t.Run(tc, func(t *testing.T) {
+			t.Parallel()
+			cmd, err := ParseCommand(tc)
+			if cmd != nil {
+				t.Fatalf("expected nil command, got: %v", cmd)
+			}
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestParseCommand_AddDir(t *testing.T) {
+	tests := []struct {
+		in       string
+		path     string
+		glob     string
+		maxFiles int
+	}{
+		{"add dir app /var/log/app", "/var/log/app", "", 0},
+		{"add dir --glob='*.log' app /var/log/app", "/var/log/app", "*.log", 0},
+		{"add dir app /var/log/app --glob=worker-? --max-files=10", "/var/log/app", "worker-?", 10},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			t.Parallel()
+			cmd, err := ParseCommand(tc.in)
+			if err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+			if cmd.Target != CommandTargetDir {
+				t.Fatal("wrong command target")
+			}
+			if cmd.Path != tc.path {
+				t.Fatal("wrong path:", cmd.Path)
+			}
+			if cmd.Glob != tc.glob {
+				t.Fatal("wrong glob:", cmd.Glob)
+			}
+			if cmd.MaxFiles != tc.maxFiles {
+				t.Fatal("wrong max files:", cmd.MaxFiles)
+			}
+		})
+	}
+}
+
+func TestParseCommand_AddFileGlob(t *testing.T) {
+	// Whether it is a glob is only known when it is attached.
+	cmd, err := ParseCommand("add file --max-files=5 app '/var/log/app/*.log'")
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+	if cmd.Target != CommandTargetFile || cmd.Path != "/var/log/app/*.log" || cmd.MaxFiles != 5 {
+		t.Fatalf("wrong command: %v %s %d", cmd.Target, cmd.Path, cmd.MaxFiles)
+	}
+}
+
+func TestParseCommand_AddDirTree(t *testing.T) {
+	tests := []struct {
+		in       string
+		maxDepth int
+		include  []string
+		exclude  []string
+	}{
+		{"add dir app /srv/out", 0, nil, nil},
+		{"add dir --max-depth=3 app /srv/out", 3, nil, nil},
+		{"add dir app /srv/out --include=log.txt --include='*/link/*'", 0, []string{"log.txt", "*/link/*"}, nil},
+		{"add dir --max-depth=2 --exclude=tmp app /srv/out --exclude='*.gz'", 2, nil, []string{"tmp", "*.gz"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			t.Parallel()
+			cmd, err := ParseCommand(tc.in)
+			if err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+			if cmd.MaxDepth != tc.maxDepth {
+				t.Fatal("wrong max depth:", cmd.MaxDepth)
+			}
+			if !slices.Equal(cmd.Include, tc.include) {
+				t.Fatal("wrong include:", cmd.Include)
+			}
+			if !slices.Equal(cmd.Exclude, tc.exclude) {
+				t.Fatal("wrong exclude:", cmd.Exclude)
+			}
+		})
+	}
+}
+
+func TestParseCommand_AddDirErrors(t *testing.T) {
+	tests := []string{
+		"add dir app",
+		"add dir --glob=a/b app /var/log/app",
+		"add dir --glob='[' app /var/log/app",
+		"add dir --max-files=0 app /var/log/app",
+		"add dir --follow=name app /var/log/app",
+		"add proc --glob=*.log app ./worker",
+		"add file --max-files=5 app /var/log/app/worker.log",
+		"add dir --max-depth=-1 app /var/log/app",
+		"add dir --include= app /var/log/app",
+		"add dir --exclude=/tmp app /var/log/app",
+		"add dir --include='[' app /var/log/app",
+	}
+
+	for _, tc := range tests {
+		t.Run(tc, func(t *testing.T) {
+			t.Parallel()
+			cmd, err := ParseCommand(tc)
+			if cmd != nil {
+				t.Fatalf("expected nil command, got: %v", cmd)
+			}
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/mdsn/gather/lib/format"
+	"github.com/mdsn/gather/lib/source"
+)
+
+// Parses the value of an `add` option into the command.
+type optionFunc func(cmd *Command, value string) error
+
+var fileOptions = map[string]optionFunc{
+	"follow":   parseFollow,
+	"from":     parseFrom,
+	"lines":    parseStartLines,
+	"fallback": parseFallback,
+	"watch":    parseWatch,
+	// Only with a glob in the path.
+	"max-files": parseMaxFiles,
+}
+
+// A dir attaches its files as file sources; they take the same starting
+// point, which applies to the files already there.
+var dirOptions = map[string]optionFunc{
+	"glob":      parseGlob,
+	"max-files": parseMaxFiles,
+	"max-depth": parseMaxDepth,
+	"include":   patternOption(func(cmd *Command) *[]string { return &cmd.Include }),
+	"exclude":   patternOption(func(cmd *Command) *[]string { return &cmd.Exclude }),
+	"from":      parseFrom,
+	"lines":     parseStartLines,
+	"fallback":  parseFallback,
+	"watch":     parseWatch,
+}
+
+var procOptions = map[string]optionFunc{
+	"stderr":              parseStderr,
+	"restart":             parseRestart,
+	"restart-backoff":     durationOption(func(cmd *Command) *time.Duration { return &cmd.Restart.Backoff }),
+	"restart-max-backoff": durationOption(func(cmd *Command) *time.Duration { return &cmd.Restart.MaxBackoff }),
+	"restart-window":      durationOption(func(cmd *Command) *time.Duration { return &cmd.Restart.Window }),
+	"restart-max":         parseRestartMax,
+	"stop-signal":         parseStopSignal,
+	"stop-timeout":        durationOption(func(cmd *Command) *time.Duration { return &cmd.StopTimeout }),
+}
+
+var tailOptions = map[string]optionFunc{
+	"format": parseFormat,
+}
+
+var historyOptions = map[string]optionFunc{
+	"format": parseFormat,
+	"lines":  parseLines,
+	"since":  durationOption(func(cmd *Command) *time.Duration { return &cmd.Since }),
+	"follow": parseLive,
+}
+
+var pauseOptions = map[string]optionFunc{
+	"discard": parseDiscard,
+	"buffer":  parseBuffer,
+}
+
+var resumeOptions = map[string]optionFunc{
+	"skip": parseSkip,
+}
+
+// Options are given as `--name=value`.
+func isOption(tok string) bool {
+	return len(tok) > 2 && strings.HasPrefix(tok, "--")
+}
+
+// Parse a single option of the named command.
+func parseOption(cmd *Command, command string, options map[string]optionFunc, tok string) error {
+	name, value, _ := strings.Cut(strings.TrimPrefix(tok, "--"), "=")
+	parse, ok := options[name]
+	if !ok {
+		return errors.New(fmt.Sprintf("%s: unknown option '--%s'", command, name))
+	}
+	if err := parse(cmd, value); err != nil {
+		return fmt.Errorf("%s: --%s: %v", command, name, err)
+	}
+	return nil
+}
+
+func parseFollow(cmd *Command, value string) error {
+	switch value {
+	case "descriptor":
+		cmd.Follow = source.FollowDescriptor
+	case "name":
+		cmd.Follow = source.FollowName
+	default:
+		return errors.New(fmt.Sprintf("expected 'descriptor' or 'name', got '%s'", value))
+	}
+	return nil
+}
+
+func parseFormat(cmd *Command, value string) error {
+	f, err := format.Parse(value)
+	if err != nil {
+		return err
+	}
+	cmd.Format = f
+	return nil
+}
+
+func parseLines(cmd *Command, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return errors.New(fmt.Sprintf("expected a positive integer, got '%s'", value))
+	}
+	cmd.Lines = n
+	return nil
+}
+
+// A flag, given without a value.
+func parseLive(cmd *Command, value string) error {
+	if value != "" {
+		return errors.New(fmt.Sprintf("takes no value, got '%s'", value))
+	}
+	cmd.Live = true
+	return nil
+}
+
+func parseDiscard(cmd *Command, value string) error {
+	if value != "" {
+		return errors.New(fmt.Sprintf("takes no value, got '%s'", value))
+	}
+	if cmd.Pause.Buffer != 0 {
+		return errors.New("cannot be combined with --buffer")
+	}
+	cmd.Pause.Discard = true
+	return nil
+}
+
+func parseBuffer(cmd *Command, value string) error {
+	if cmd.Pause.Discard {
+		return errors.New("cannot be combined with --discard")
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return errors.New(fmt.Sprintf("expected a positive integer, got '%s'", value))
+	}
+	cmd.Pause.Buffer = n
+	return nil
+}
+
+func parseSkip(cmd *Command, value string) error {
+	if value != "" {
+		return errors.New(fmt.Sprintf("takes no value, got '%s'", value))
+	}
+	cmd.Resume.Skip = true
+	return nil
+}
+
+// `end`, `start` or `offset:N`. Cannot be combined with --lines.
+func parseFrom(cmd *Command, value string) error {
+	if cmd.Start.Mode == source.StartLines {
+		return errors.New("cannot be combined with --lines")
+	}
+
+	switch {
+	case value == "end":
+		cmd.Start.Mode = source.StartEnd
+	case value == "start":
+		cmd.Start.Mode = source.StartBeginning
+	case strings.HasPrefix(value, "offset:"):
+		n, err := strconv.ParseInt(strings.TrimPrefix(value, "offset:"), 10, 64)
+		if err != nil || n < 0 {
+			return errors.New(fmt.Sprintf("expected a non-negative offset, got '%s'", value))
+		}
+		cmd.Start.Mode = source.StartOffset
+		cmd.Start.Offset = n
+	default:
+		return errors.New(fmt.Sprintf("expected 'end', 'start' or 'offset:N', got '%s'", value))
+	}
+	return nil
+}
+
+// Start from the last N lines of the file.
+func parseStartLines(cmd *Command, value string) error {
+	if cmd.Start.Mode == source.StartBeginning || cmd.Start.Mode == source.StartOffset {
+		return errors.New("cannot be combined with --from")
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return errors.New(fmt.Sprintf("expected a positive integer, got '%s'", value))
+	}
+	cmd.Start.Mode = source.StartLines
+	cmd.Start.Lines = n
+	return nil
+}
+
+func parseFallback(cmd *Command, value string) error {
+	switch value {
+	case "from":
+		cmd.Fallback = source.FallbackFrom
+	case "start":
+		cmd.Fallback = source.FallbackBeginning
+	case "end":
+		cmd.Fallback = source.FallbackEnd
+	default:
+		return errors.New(fmt.Sprintf("expected 'from', 'start' or 'end', got '%s'", value))
+	}
+	return nil
+}
+
+func parseWatch(cmd *Command, value string) error {
+	switch value {
+	case "auto":
+		cmd.Watch = source.WatchAuto
+	case "inotify":
+		cmd.Watch = source.WatchInotify
+	case "poll":
+		cmd.Watch = source.WatchPoll
+	default:
+		return errors.New(fmt.Sprintf("expected 'auto', 'inotify' or 'poll', got '%s'", value))
+	}
+	return nil
+}
+
+func parseGlob(cmd *Command, value string) error {
+	if _, err := path.Match(value, ""); err != nil || value == "" || strings.Contains(value, "/") {
+		return errors.New(fmt.Sprintf("expected a file name pattern, got '%s'", value))
+	}
+	cmd.Glob = value
+	return nil
+}
+
+func parseMaxFiles(cmd *Command, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return errors.New(fmt.Sprintf("expected a positive integer, got '%s'", value))
+	}
+	cmd.MaxFiles = n
+	return nil
+}
+
+// Levels of subdirectories to watch below the directory.
+func parseMaxDepth(cmd *Command, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return errors.New(fmt.Sprintf("expected a non-negative integer, got '%s'", value))
+	}
+	cmd.MaxDepth = n
+	return nil
+}
+
+// A repeatable option holding a pattern matched against file names, or
+// against relative paths if it has a slash, appended to the field returned
+// by field.
+func patternOption(field func(cmd *Command) *[]string) optionFunc {
+	return func(cmd *Command, value string) error {
+		if _, err := path.Match(value, ""); err != nil || value == "" || strings.HasPrefix(value, "/") {
+			return errors.New(fmt.Sprintf("expected a relative pattern, got '%s'", value))
+		}
+		*field(cmd) = append(*field(cmd), value)
+		return nil
+	}
+}
+
+func parseStderr(cmd *Command, value string) error {
+	switch value {
+	case "merge":
+		cmd.Stderr = source.StderrMerge
+	case "tag":
+		cmd.Stderr = source.StderrTag
+	default:
+		return errors.New(fmt.Sprintf("expected 'merge' or 'tag', got '%s'", value))
+	}
+	return nil
+}
+
+func parseRestart(cmd *Command, value string) error {
+	switch value {
+	case "never":
+		cmd.Restart.Mode = source.RestartNever
+	case "on-failure":
+		cmd.Restart.Mode = source.RestartOnFailure
+	case "always":
+		cmd.Restart.Mode = source.RestartAlways
+	default:
+		return errors.New(fmt.Sprintf("expected 'never', 'on-failure' or 'always', got '%s'", value))
+	}
+	return nil
+}
+
+func parseRestartMax(cmd *Command, value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return errors.New(fmt.Sprintf("expected a non-negative integer, got '%s'", value))
+	}
+	cmd.Restart.MaxRestarts = n
+	return nil
+}
+
+// An option holding a positive duration, e.g. `5s` or `1m30s`, stored in the
+// field returned by field.
+func durationOption(field func(cmd *Command) *time.Duration) optionFunc {
+	return func(cmd *Command, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return errors.New(fmt.Sprintf("expected a positive duration, got '%s'", value))
+		}
+		*field(cmd) = d
+		return nil
+	}
+}
+
+// A signal by name, with or without the SIG prefix, or by number.
+func parseStopSignal(cmd *Command, value string) error {
+	if n, err := strconv.Atoi(value); err == nil && n > 0 && unix.SignalName(syscall.Signal(n)) != "" {
+		cmd.StopSignal = syscall.Signal(n)
+		return nil
+	}
+
+	name := strings.ToUpper(value)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig := unix.SignalNum(name)
+	if sig == 0 {
+		return errors.New(fmt.Sprintf("unknown signal '%s'", value))
+	}
+	cmd.StopSignal = sig
+	return nil
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Every command is answered with a single status line, either `ok` or
+// `err <code> <message>`. Commands that produce output, like `ls`, write it
+// before the status line.
+const (
+	ReplyOk  = "ok"
+	ReplyErr = "err"
+)
+
+// Stable, machine-readable error codes sent back to clients.
+type ErrorCode string
+
+const (
+	// The command could not be parsed.
+	ErrCodeParse ErrorCode = "parse"
+	// The source id is already in use.
+	ErrCodeExists ErrorCode = "exists"
+	// No source with the given id.
+	ErrCodeNotFound ErrorCode = "not-found"
+	// The source could not be attached.
+	ErrCodeAttach ErrorCode = "attach"
+	// The source has exited.
+	ErrCodeNotRunning ErrorCode = "not-running"
+	// The client is not allowed to run the command.
+	ErrCodeDenied ErrorCode = "denied"
+	// Anything else.
+	ErrCodeInternal ErrorCode = "internal"
+)
+
+// An error reply, as sent to or received from a client.
+type Error struct {
+	Code ErrorCode
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Msg)
+}
+
+func WriteOk(w io.Writer) error {
+	_, err := fmt.Fprintln(w, ReplyOk)
+	return err
+}
+
+// Write an error reply with the given code. Newlines in the message are
+// flattened so the reply stays on a single line.
+func WriteErr(w io.Writer, code ErrorCode, err error) error {
+	msg := strings.ReplaceAll(err.Error(), "\n", " ")
+	_, werr := fmt.Fprintf(w, "%s %s %s\n", ReplyErr, code, msg)
+	return werr
+}
+
+// Parse a status line. Returns nil for `ok` and an *Error for `err` replies.
+func ParseReply(line string) error {
+	line = strings.TrimRight(line, "\r\n")
+	if line == ReplyOk {
+		return nil
+	}
+
+	rest, ok := strings.CutPrefix(line, ReplyErr+" ")
+	if !ok {
+		return errors.New(fmt.Sprintf("malformed reply '%s'", line))
+	}
+
+	code, msg, _ := strings.Cut(rest, " ")
+	return &Error{Code: ErrorCode(code), Msg: msg}
+}
+
+// Whether the line is a status line terminating a reply.
+func IsStatusLine(line string) bool {
+	line = strings.TrimRight(line, "\r\n")
+	return line == ReplyOk || strings.HasPrefix(line, ReplyErr+" ")
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestWriteOk(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteOk(&buf); err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	if buf.String() != "ok\n" {
+		t.Fatalf("wrong reply: %q", buf.String())
+	}
+}
+
+func TestWriteErr_FlattensNewlines(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteErr(&buf, ErrCodeAttach, errors.New("no such file\nor directory"))
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	if buf.String() != "err attach no such file or directory\n" {
+		t.Fatalf("wrong reply: %q", buf.String())
+	}
+}
+
+func TestParseReply(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		code ErrorCode
+		msg  string
+	}{
+		{"ok", "ok\n", "", ""},
+		{"err", "err not-found source 'x' not found\n", ErrCodeNotFound, "source 'x' not found"},
+		{"err-no-msg", "err internal", ErrCodeInternal, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			err := ParseReply(tc.in)
+			if tc.code == "" {
+				if err != nil {
+					t.Fatalf("got err: %v", err)
+				}
+				return
+			}
+
+			var rerr *Error
+			if !errors.As(err, &rerr) {
+				t.Fatalf("expected *Error, got: %v", err)
+			}
+
+			if rerr.Code != tc.code {
+				t.Fatal("wrong code:", rerr.Code)
+			}
+
+			if rerr.Msg != tc.msg {
+				t.Fatalf("wrong message: '%s'", rerr.Msg)
+			}
+		})
+	}
+}
+
+func TestParseReply_Malformed(t *testing.T) {
+	for _, in := range []string{"", "okay", "error", "err"} {
+		t.Run(in, func(t *testing.T) {
+			t.Parallel()
+			err := ParseReply(in)
+			if err == nil {
+				t.Fatal("expected error, got nil")
+			}
+
+			var rerr *Error
+			if errors.As(err, &rerr) {
+				t.Fatalf("expected malformed reply error, got: %v", rerr)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A malformed command line. Col is the 1-based column, in bytes, where the
+// offending construct starts.
+type SyntaxError struct {
+	Col int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Col, e.Msg)
+}
+
+// Split a command line into words the way a POSIX shell would, minus any
+// expansions. Words are separated by blanks. Single quotes preserve
+// everything up to the closing quote. Double quotes preserve everything but
+// backslash escapes of `"`, `\`, `$` and backquote. Outside of quotes, a
+// backslash preserves the character following it.
+func tokens(in string) ([]string, error) {
+	var toks []string
+	var tok strings.Builder
+	// Whether a word was started; quotes may produce an empty one.
+	inWord := false
+
+	for i := 0; i < len(in); i++ {
+		c := in[i]
+		switch {
+		case c == ' ' || c == '\t':
+			if inWord {
+				toks = append(toks, tok.String())
+				tok.Reset()
+				inWord = false
+			}
+
+		case c == '\'':
+			end := strings.IndexByte(in[i+1:], '\'')
+			if end == -1 {
+				return nil, &SyntaxError{Col: i + 1, Msg: "unterminated single quote"}
+			}
+			tok.WriteString(in[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+
+		case c == '"':
+			start := i
+			closed := false
+			for i++; i < len(in); i++ {
+				if in[i] == '"' {
+					closed = true
+					break
+				}
+				if in[i] == '\\' && i+1 < len(in) && strings.IndexByte("\"\\$`", in[i+1]) != -1 {
+					i++
+				}
+				tok.WriteByte(in[i])
+			}
+			if !closed {
+				return nil, &SyntaxError{Col: start + 1, Msg: "unterminated double quote"}
+			}
+			inWord = true
+
+		case c == '\\':
+			if i+1 == len(in) {
+				return nil, &SyntaxError{Col: i + 1, Msg: "trailing backslash"}
+			}
+			i++
+			tok.WriteByte(in[i])
+			inWord = true
+
+		default:
+			tok.WriteByte(c)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		toks = append(toks, tok.String())
+	}
+	return toks, nil
+}
+
+// Quote a word so that tokens() reads it back as is. Words made only of
+// characters without special meaning are left alone.
+func Quote(word string) string {
+	if word != "" && strings.IndexFunc(word, needsQuote) == -1 {
+		return word
+	}
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// Quote each word and join them into a command line.
+func Join(words []string) string {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = Quote(w)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func needsQuote(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\'' || r == '"' || r == '\\'
+}
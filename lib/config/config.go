@@ -0,0 +1,62 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mdsn/gather/lib/api"
+)
+
+// Read the sources listed in a config file. The file has one `add` command
+// per line, exactly as sent over the socket. Blank lines and lines starting
+// with `#` are skipped.
+func Load(path string) ([]*api.Command, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	cmds, err := Parse(fp)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", path, err)
+	}
+	return cmds, nil
+}
+
+// Parse the lines of a config file. Errors are prefixed by the line number.
+func Parse(r io.Reader) ([]*api.Command, error) {
+	var cmds []*api.Command
+	ids := make(map[string]int)
+
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		cmd, err := api.ParseCommand(line)
+		if err != nil {
+			return nil, fmt.Errorf("%d: %w", n, err)
+		}
+		if cmd.Kind != api.CommandKindAdd {
+			return nil, errors.New(fmt.Sprintf("%d: only 'add' commands are allowed", n))
+		}
+		if prev, ok := ids[cmd.Id]; ok {
+			return nil, errors.New(fmt.Sprintf("%d: id '%s' already used on line %d", n, cmd.Id, prev))
+		}
+
+		ids[cmd.Id] = n
+		cmds = append(cmds, cmd)
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return cmds, nil
+}
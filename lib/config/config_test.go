@@ -0,0 +1,70 @@
+package config
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mdsn/gather/lib/api"
+)
+
+func TestParse(t *testing.T) {
+	in := `
+# System logs
+add file syslog /var/log/syslog
+
+  add proc --restart=always worker ./worker -v   
+`
+	cmds, err := Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	if len(cmds) != 2 {
+		t.Fatal("wrong number of commands:", len(cmds))
+	}
+	if cmds[0].Id != "syslog" || cmds[0].Target != api.CommandTargetFile {
+		t.Fatalf("unexpected command: %+v", cmds[0])
+	}
+	if cmds[1].Id != "worker" || len(cmds[1].Args) != 1 {
+		t.Fatalf("unexpected command: %+v", cmds[1])
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		line string
+	}{
+		{"parse", "add file syslog /var/log/syslog\nadd rhubarb x y\n", "2:"},
+		{"not-add", "# comment\nls\n", "2:"},
+		{"duplicate", "add file a /tmp/a\n\nadd file a /tmp/b\n", "3:"},
+		{"quote", "add proc w sh -c 'echo\n", "1: column 18"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			cmds, err := Parse(strings.NewReader(tc.in))
+			if cmds != nil {
+				t.Fatalf("expected nil commands, got: %v", cmds)
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tc.line) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestLoad_PathInError(t *testing.T) {
+	path := t.TempDir() + "/gather.conf"
+	if err := os.WriteFile(path, []byte("rm syslog\n"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	_, err := Load(path)
+	if err == nil || !strings.HasPrefix(err.Error(), path+":1:") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
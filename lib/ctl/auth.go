@@ -0,0 +1,106 @@
+package ctl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+)
+
+// What a client may do. Each level includes the ones before it.
+type Access uint8
+
+const (
+	AccessNone Access = iota
+	// Commands that only look: ls, tail, history.
+	AccessRead
+	// Also add, remove and control file sources.
+	AccessFile
+	// Also add, remove and control proc sources, i.e. run programs as
+	// gather's user.
+	AccessProc
+)
+
+func (a Access) String() string {
+	switch a {
+	case AccessNone:
+		return "none"
+	case AccessRead:
+		return "read"
+	case AccessFile:
+		return "file"
+	case AccessProc:
+		return "proc"
+	default:
+		return "unknown"
+	}
+}
+
+func ParseAccess(s string) (Access, error) {
+	switch s {
+	case "none":
+		return AccessNone, nil
+	case "read":
+		return AccessRead, nil
+	case "file":
+		return AccessFile, nil
+	case "proc":
+		return AccessProc, nil
+	default:
+		return 0, errors.New(fmt.Sprintf("unknown access level '%s'", s))
+	}
+}
+
+// Grants access to clients by uid and gid. A client gets the highest level
+// granted to its uid or its primary gid. Root and the user gather runs as
+// always have full access.
+type Policy struct {
+	uids map[int]Access
+	gids map[int]Access
+}
+
+func NewPolicy() *Policy {
+	p := &Policy{
+		uids: make(map[int]Access),
+		gids: make(map[int]Access),
+	}
+	p.uids[0] = AccessProc
+	p.uids[os.Getuid()] = AccessProc
+	return p
+}
+
+// Add a rule of the form `user:level` or `@group:level`, with users and
+// groups given by name or number.
+func (p *Policy) Allow(rule string) error {
+	who, level, ok := strings.Cut(rule, ":")
+	if !ok || who == "" || who == "@" {
+		return errors.New(fmt.Sprintf("expected user:level or @group:level, got '%s'", rule))
+	}
+
+	access, err := ParseAccess(level)
+	if err != nil {
+		return err
+	}
+
+	if group, ok := strings.CutPrefix(who, "@"); ok {
+		gid, err := lookupId(group, user.LookupGroup, func(g *user.Group) string { return g.Gid })
+		if err != nil {
+			return err
+		}
+		p.gids[gid] = access
+		return nil
+	}
+
+	uid, err := lookupId(who, user.Lookup, func(u *user.User) string { return u.Uid })
+	if err != nil {
+		return err
+	}
+	p.uids[uid] = access
+	return nil
+}
+
+// The access granted to peer.
+func (p *Policy) Access(peer Peer) Access {
+	return max(p.uids[peer.Uid], p.gids[peer.Gid])
+}
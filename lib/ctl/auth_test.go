@@ -0,0 +1,100 @@
+package ctl
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+func TestPolicy_Access(t *testing.T) {
+	p := NewPolicy()
+	for _, rule := range []string{"1000:read", "1001:proc", "@2000:file", "1002:none", "@2001:read"} {
+		if err := p.Allow(rule); err != nil {
+			t.Fatalf("Allow(%s): %v", rule, err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		peer Peer
+		want Access
+	}{
+		{"root", Peer{Uid: 0, Gid: 0}, AccessProc},
+		{"self", Peer{Uid: os.Getuid(), Gid: 99999}, AccessProc},
+		{"stranger", Peer{Uid: 4242, Gid: 4242}, AccessNone},
+		{"uid", Peer{Uid: 1000, Gid: 4242}, AccessRead},
+		{"uid-proc", Peer{Uid: 1001, Gid: 4242}, AccessProc},
+		{"gid", Peer{Uid: 4242, Gid: 2000}, AccessFile},
+		{"highest-wins", Peer{Uid: 1000, Gid: 2000}, AccessFile},
+		{"none-does-not-revoke-group", Peer{Uid: 1002, Gid: 2001}, AccessRead},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if got := p.Access(tc.peer); got != tc.want {
+				t.Fatalf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_AllowErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"1000",
+		":read",
+		"@:read",
+		"1000:write",
+		"no-such-user-here:read",
+		"@no-such-group-here:read",
+	}
+
+	for _, tc := range tests {
+		t.Run(tc, func(t *testing.T) {
+			t.Parallel()
+			if err := NewPolicy().Allow(tc); err == nil {
+				t.Fatal("expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestPeerCred(t *testing.T) {
+	path := t.TempDir() + "/gather"
+	l, err := Listen(path, 1, DefaultPerm)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+
+	connC := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(connC)
+			return
+		}
+		connC <- conn
+	}()
+
+	client, err := Dial(path)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer client.Close()
+
+	conn, ok := <-connC
+	if !ok {
+		t.Fatal("accept failed")
+	}
+	defer conn.Close()
+
+	peer, err := PeerCred(conn)
+	if err != nil {
+		t.Fatalf("PeerCred: %v", err)
+	}
+	if peer.Pid != os.Getpid() || peer.Uid != os.Getuid() || peer.Gid != os.Getgid() {
+		t.Fatal("unexpected peer:", peer)
+	}
+}
@@ -0,0 +1,49 @@
+package ctl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// The process at the other end of a connection, as it was when it
+// connected.
+type Peer struct {
+	Pid int
+	Uid int
+	Gid int
+}
+
+func (p Peer) String() string {
+	return fmt.Sprintf("pid=%d uid=%d gid=%d", p.Pid, p.Uid, p.Gid)
+}
+
+// Read the credentials of the peer of a UNIX socket connection with
+// SO_PEERCRED.
+func PeerCred(conn net.Conn) (Peer, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return Peer{}, errors.New("not a unix socket connection")
+	}
+
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return Peer{}, err
+	}
+
+	var cred *unix.Ucred
+	var cerr error
+	err = raw.Control(func(fd uintptr) {
+		cred, cerr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return Peer{}, err
+	}
+	if cerr != nil {
+		return Peer{}, cerr
+	}
+
+	return Peer{Pid: int(cred.Pid), Uid: int(cred.Uid), Gid: int(cred.Gid)}, nil
+}
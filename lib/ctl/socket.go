@@ -0,0 +1,181 @@
+package ctl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Environment variable holding the socket path, overriding the default.
+const EnvSocket = "GATHER_SOCKET"
+
+var ErrInUse = errors.New("another instance is listening")
+
+// The socket path to use when none is given: $GATHER_SOCKET, else `gather`
+// in $XDG_RUNTIME_DIR, else /tmp/gather.
+func DefaultPath() string {
+	if path := os.Getenv(EnvSocket); path != "" {
+		return path
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "gather")
+	}
+	return "/tmp/gather"
+}
+
+// Whether path names a socket in the Linux abstract namespace, written with
+// a leading `@`. Abstract sockets have no file, and go away with the last
+// descriptor referring to them.
+func IsAbstract(path string) bool {
+	return strings.HasPrefix(path, "@")
+}
+
+// Ownership and mode given to the socket file. Ignored for abstract sockets.
+type Perm struct {
+	Mode os.FileMode
+	// -1 leaves the owner or group as created.
+	Uid int
+	Gid int
+}
+
+var DefaultPerm = Perm{Mode: 0600, Uid: -1, Gid: -1}
+
+// Parse `user`, `user:group` or `:group` into a Perm's Uid and Gid. Users and
+// groups are given by name or number. Whatever is left out stays -1.
+func ParseOwner(s string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	name, group, _ := strings.Cut(s, ":")
+
+	if name != "" {
+		if uid, err = lookupId(name, user.Lookup, func(u *user.User) string { return u.Uid }); err != nil {
+			return -1, -1, err
+		}
+	}
+	if group != "" {
+		if gid, err = lookupId(group, user.LookupGroup, func(g *user.Group) string { return g.Gid }); err != nil {
+			return -1, -1, err
+		}
+	}
+	return uid, gid, nil
+}
+
+// A numeric id as is, or the id of the user or group with the given name.
+func lookupId[T any](name string, lookup func(string) (T, error), id func(T) string) (int, error) {
+	if n, err := strconv.Atoi(name); err == nil && n >= 0 {
+		return n, nil
+	}
+	v, err := lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id(v))
+}
+
+// Create a socket at path and listen on it. A socket file left behind by an
+// instance that is gone is replaced, but ErrInUse is returned if a live one
+// answers on it.
+func Listen(path string, backlog int, perm Perm) (net.Listener, error) {
+	sfd, err := bind(path)
+	if errors.Is(err, unix.EADDRINUSE) && !IsAbstract(path) {
+		if live(path) {
+			return nil, fmt.Errorf("%s: %w", path, ErrInUse)
+		}
+		// Nobody home; the file is stale. Never remove anything else.
+		if st, err := os.Lstat(path); err != nil || st.Mode().Type() != os.ModeSocket {
+			return nil, errors.New(fmt.Sprintf("%s: exists and is not a socket", path))
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+		sfd, err = bind(path)
+	}
+	if errors.Is(err, unix.EADDRINUSE) {
+		return nil, fmt.Errorf("%s: %w", path, ErrInUse)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bind: %w", err)
+	}
+
+	// Connecting takes listen(2), so nobody gets in before the permissions
+	// are set.
+	if !IsAbstract(path) {
+		if err := chperm(path, perm); err != nil {
+			unix.Close(sfd)
+			os.Remove(path)
+			return nil, err
+		}
+	}
+
+	if err := unix.Listen(sfd, backlog); err != nil {
+		unix.Close(sfd)
+		Remove(path)
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+
+	// Hand the socket over to net for a listener whose Accept can be
+	// interrupted. FileListener works on a dup, so the original goes away.
+	sf := os.NewFile(uintptr(sfd), path)
+	l, err := net.FileListener(sf)
+	sf.Close()
+	if err != nil {
+		Remove(path)
+		return nil, fmt.Errorf("listener: %w", err)
+	}
+	return l, nil
+}
+
+// Connect to the socket at path.
+func Dial(path string) (*net.UnixConn, error) {
+	return net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+}
+
+// Remove the socket file at path, if it has one.
+func Remove(path string) {
+	if !IsAbstract(path) {
+		_ = os.Remove(path)
+	}
+}
+
+func bind(path string) (int, error) {
+	// Close on exec, or proc sources inherit the socket.
+	sfd, err := unix.Socket(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, fmt.Errorf("socket: %w", err)
+	}
+
+	// x/sys/unix turns a leading @ into the abstract namespace.
+	if err := unix.Bind(sfd, &unix.SockaddrUnix{Name: path}); err != nil {
+		unix.Close(sfd)
+		return -1, err
+	}
+	return sfd, nil
+}
+
+// Whether something accepts connections at path.
+func live(path string) bool {
+	conn, err := Dial(path)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func chperm(path string, perm Perm) error {
+	if err := os.Chmod(path, perm.Mode); err != nil {
+		return err
+	}
+	if perm.Uid != -1 || perm.Gid != -1 {
+		if err := os.Chown(path, perm.Uid, perm.Gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
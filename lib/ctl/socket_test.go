@@ -0,0 +1,155 @@
+package ctl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestDefaultPath(t *testing.T) {
+	t.Setenv(EnvSocket, "")
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	if path := DefaultPath(); path != "/tmp/gather" {
+		t.Fatal("unexpected path:", path)
+	}
+
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	if path := DefaultPath(); path != "/run/user/1000/gather" {
+		t.Fatal("unexpected path:", path)
+	}
+
+	t.Setenv(EnvSocket, "@gather-test")
+	if path := DefaultPath(); path != "@gather-test" {
+		t.Fatal("unexpected path:", path)
+	}
+}
+
+func TestListen_Mode(t *testing.T) {
+	path := t.TempDir() + "/gather"
+	l, err := Listen(path, 1, Perm{Mode: 0660, Uid: -1, Gid: -1})
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if st.Mode().Type() != os.ModeSocket {
+		t.Fatal("not a socket:", st.Mode())
+	}
+	if st.Mode().Perm() != 0660 {
+		t.Fatal("wrong mode:", st.Mode().Perm())
+	}
+}
+
+func TestListen_LiveInstance(t *testing.T) {
+	path := t.TempDir() + "/gather"
+	l, err := Listen(path, 1, DefaultPerm)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+
+	go func() {
+		// Answer the liveness probe.
+		if conn, err := l.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	if _, err := Listen(path, 1, DefaultPerm); !errors.Is(err, ErrInUse) {
+		t.Fatalf("expected ErrInUse, got: %v", err)
+	}
+
+	// The socket of the live instance is left alone.
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("socket gone: %v", err)
+	}
+}
+
+func TestListen_StaleSocket(t *testing.T) {
+	path := t.TempDir() + "/gather"
+
+	// A socket file nobody listens on, as left by a crashed instance.
+	sfd, err := bind(path)
+	if err != nil {
+		t.Fatalf("bind: %v", err)
+	}
+	unix.Close(sfd)
+
+	l, err := Listen(path, 1, DefaultPerm)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	l.Close()
+}
+
+func TestListen_NotASocket(t *testing.T) {
+	path := t.TempDir() + "/gather"
+	if err := os.WriteFile(path, []byte("precious"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	if _, err := Listen(path, 1, DefaultPerm); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	if b, err := os.ReadFile(path); err != nil || string(b) != "precious" {
+		t.Fatalf("file clobbered: %v", err)
+	}
+}
+
+func TestListen_Abstract(t *testing.T) {
+	path := fmt.Sprintf("@gather-test-%d", os.Getpid())
+	l, err := Listen(path, 1, DefaultPerm)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+
+	if _, err := Listen(path, 1, DefaultPerm); !errors.Is(err, ErrInUse) {
+		t.Fatalf("expected ErrInUse, got: %v", err)
+	}
+
+	conn, err := Dial(path)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	conn.Close()
+}
+
+func TestParseOwner(t *testing.T) {
+	tests := []struct {
+		in       string
+		uid, gid int
+	}{
+		{"", -1, -1},
+		{"0", 0, -1},
+		{"root", 0, -1},
+		{"root:root", 0, 0},
+		{":0", -1, 0},
+		{"1000:1000", 1000, 1000},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			t.Parallel()
+			uid, gid, err := ParseOwner(tc.in)
+			if err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+			if uid != tc.uid || gid != tc.gid {
+				t.Fatalf("got %d:%d, want %d:%d", uid, gid, tc.uid, tc.gid)
+			}
+		})
+	}
+
+	if _, _, err := ParseOwner("no-such-user-here"); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
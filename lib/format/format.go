@@ -0,0 +1,203 @@
+package format
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mdsn/gather/lib/source"
+)
+
+// How records of the aggregated stream are written out.
+type Format uint8
+
+const (
+	// `id: line`, with stderr lines under `id[stderr]`.
+	FormatText Format = iota
+	// One JSON object per line.
+	FormatJSON
+	// One logfmt record per line.
+	FormatLogfmt
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatText:
+		return "text"
+	case FormatJSON:
+		return "json"
+	case FormatLogfmt:
+		return "logfmt"
+	default:
+		return "unknown"
+	}
+}
+
+func Parse(name string) (Format, error) {
+	switch name {
+	case "text":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	case "logfmt":
+		return FormatLogfmt, nil
+	default:
+		return 0, errors.New(fmt.Sprintf("unknown format '%s'", name))
+	}
+}
+
+// Write a single record, terminated by a newline.
+func (f Format) Write(w io.Writer, out *source.Output) error {
+	switch f {
+	case FormatText:
+		return writeText(w, out)
+	case FormatJSON:
+		return writeJSON(w, out)
+	case FormatLogfmt:
+		return writeLogfmt(w, out)
+	default:
+		return errors.New("unknown format")
+	}
+}
+
+// Write a marker for n records a subscriber did not get to see.
+func (f Format) WriteDropped(w io.Writer, n int, at time.Time) error {
+	msg := fmt.Sprintf("%d lines dropped", n)
+	switch f {
+	case FormatText:
+		_, err := fmt.Fprintf(w, "-- %s\n", msg)
+		return err
+	case FormatJSON:
+		return json.NewEncoder(w).Encode(&jsonDropped{
+			Time:    at.Format(time.RFC3339Nano),
+			Stream:  source.StreamEvent.String(),
+			Dropped: n,
+			Line:    msg,
+		})
+	case FormatLogfmt:
+		_, err := fmt.Fprintf(w, "time=%s stream=%s dropped=%d line=%s\n",
+			at.Format(time.RFC3339Nano), source.StreamEvent, n, logfmtValue(msg))
+		return err
+	default:
+		return errors.New("unknown format")
+	}
+}
+
+func writeText(w io.Writer, out *source.Output) error {
+	var err error
+	if out.Stream == source.StreamStderr {
+		_, err = fmt.Fprintf(w, "%s[stderr]: %s\n", out.Id, out.Bytes)
+	} else {
+		_, err = fmt.Fprintf(w, "%s: %s\n", out.Id, out.Bytes)
+	}
+	return err
+}
+
+type jsonExit struct {
+	Status     int     `json:"status"`
+	Signal     string  `json:"signal,omitempty"`
+	RuntimeSec float64 `json:"runtime_sec"`
+	MaxRSS     int64   `json:"maxrss_bytes"`
+	UserSec    float64 `json:"user_sec"`
+	SysSec     float64 `json:"sys_sec"`
+}
+
+type jsonRecord struct {
+	Id     string `json:"id"`
+	Kind   string `json:"kind"`
+	Time   string `json:"time"`
+	Stream string `json:"stream"`
+	Line   string `json:"line"`
+	// The raw line, only when it is not valid UTF-8. Line then has the
+	// invalid bytes replaced with U+FFFD.
+	LineBase64 string    `json:"line_base64,omitempty"`
+	Exit       *jsonExit `json:"exit,omitempty"`
+}
+
+type jsonDropped struct {
+	Time    string `json:"time"`
+	Stream  string `json:"stream"`
+	Dropped int    `json:"dropped"`
+	Line    string `json:"line"`
+}
+
+func writeJSON(w io.Writer, out *source.Output) error {
+	rec := jsonRecord{
+		Id:     out.Id,
+		Kind:   out.Kind.String(),
+		Time:   out.CapturedAt.Format(time.RFC3339Nano),
+		Stream: out.Stream.String(),
+		Line:   string(out.Bytes),
+	}
+
+	if !utf8.Valid(out.Bytes) {
+		rec.Line = strings.ToValidUTF8(rec.Line, "�")
+		rec.LineBase64 = base64.StdEncoding.EncodeToString(out.Bytes)
+	}
+
+	if e := out.Exit; e != nil {
+		rec.Exit = &jsonExit{
+			Status:     e.Status,
+			RuntimeSec: e.Runtime.Seconds(),
+			MaxRSS:     e.MaxRSS,
+			UserSec:    e.UserTime.Seconds(),
+			SysSec:     e.SysTime.Seconds(),
+		}
+		if e.Signal != 0 {
+			rec.Exit.Signal = source.SignalName(e.Signal)
+		}
+	}
+
+	// Encode appends the newline.
+	return json.NewEncoder(w).Encode(&rec)
+}
+
+func writeLogfmt(w io.Writer, out *source.Output) error {
+	var sb strings.Builder
+	pair := func(k, v string) {
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(logfmtValue(v))
+	}
+
+	pair("time", out.CapturedAt.Format(time.RFC3339Nano))
+	pair("id", out.Id)
+	pair("kind", out.Kind.String())
+	pair("stream", out.Stream.String())
+	if e := out.Exit; e != nil {
+		pair("status", strconv.Itoa(e.Status))
+		if e.Signal != 0 {
+			pair("signal", source.SignalName(e.Signal))
+		}
+		pair("runtime", e.Runtime.String())
+	}
+	pair("line", string(out.Bytes))
+	sb.WriteByte('\n')
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Quote a logfmt value if it is empty or has anything other than printable,
+// non-space characters without quotes or equal signs. Quoting escapes
+// invalid UTF-8 as \x sequences.
+func logfmtValue(v string) string {
+	if v == "" {
+		return `""`
+	}
+	for _, r := range v {
+		if r <= ' ' || r == '=' || r == '"' || r == '\\' || r == utf8.RuneError || !strconv.IsPrint(r) {
+			return strconv.Quote(v)
+		}
+	}
+	return v
+}
@@ -0,0 +1,185 @@
+package format
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mdsn/gather/lib/source"
+)
+
+var capturedAt = time.Date(2024, 3, 1, 12, 30, 45, 123456789, time.UTC)
+
+func output(line string) *source.Output {
+	return &source.Output{
+		Id:         "worker",
+		Kind:       source.KindProc,
+		CapturedAt: capturedAt,
+		Bytes:      []byte(line),
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, f := range []Format{FormatText, FormatJSON, FormatLogfmt} {
+		got, err := Parse(f.String())
+		if err != nil {
+			t.Fatalf("got err: %v", err)
+		}
+		if got != f {
+			t.Fatal("wrong format:", got)
+		}
+	}
+
+	if _, err := Parse("yaml"); err == nil {
+		t.Fatal("expected error, got nil")
+	}
+}
+
+func TestWrite_Text(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream source.Stream
+		want   string
+	}{
+		{"stdout", source.StreamStdout, "worker: key: value\n"},
+		{"stderr", source.StreamStderr, "worker[stderr]: key: value\n"},
+		{"event", source.StreamEvent, "worker: key: value\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			out := output("key: value")
+			out.Stream = tc.stream
+
+			var buf bytes.Buffer
+			if err := FormatText.Write(&buf, out); err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+			if buf.String() != tc.want {
+				t.Fatalf("got %q, want %q", buf.String(), tc.want)
+			}
+		})
+	}
+}
+
+func TestWrite_JSON(t *testing.T) {
+	out := output("id: not really")
+	out.Stream = source.StreamStderr
+
+	var buf bytes.Buffer
+	if err := FormatJSON.Write(&buf, out); err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	want := `{"id":"worker","kind":"proc","time":"2024-03-01T12:30:45.123456789Z",` +
+		`"stream":"stderr","line":"id: not really"}` + "\n"
+	if buf.String() != want {
+		t.Fatalf("got %s, want %s", buf.String(), want)
+	}
+}
+
+func TestWrite_JSONInvalidUTF8(t *testing.T) {
+	raw := []byte{'o', 'k', 0xff, 0xfe, '!'}
+	out := output(string(raw))
+
+	var buf bytes.Buffer
+	if err := FormatJSON.Write(&buf, out); err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	var rec jsonRecord
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	if rec.Line != "ok�!" {
+		t.Fatalf("wrong line: %q", rec.Line)
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(rec.LineBase64)
+	if err != nil {
+		t.Fatalf("base64: %v", err)
+	}
+	if !bytes.Equal(decoded, raw) {
+		t.Fatalf("raw line lost: %q", decoded)
+	}
+}
+
+func TestWrite_JSONExit(t *testing.T) {
+	out := output("exited")
+	out.Stream = source.StreamEvent
+	out.Exit = &source.Exit{Status: 137, Signal: syscall.SIGKILL, Runtime: 192 * time.Second}
+
+	var buf bytes.Buffer
+	if err := FormatJSON.Write(&buf, out); err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	var rec jsonRecord
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	if rec.Stream != "event" || rec.Exit == nil {
+		t.Fatalf("wrong record: %s", buf.String())
+	}
+	if rec.Exit.Status != 137 || rec.Exit.Signal != "KILL" || rec.Exit.RuntimeSec != 192 {
+		t.Fatalf("wrong exit: %+v", rec.Exit)
+	}
+}
+
+func TestWrite_Logfmt(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"bare", "ready", "line=ready"},
+		{"spaces", `say "hi" = ok`, `line="say \"hi\" = ok"`},
+		{"empty", "", `line=""`},
+		{"invalid-utf8", "a\xffb", `line="a\xffb"`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var buf bytes.Buffer
+			if err := FormatLogfmt.Write(&buf, output(tc.line)); err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+
+			want := "time=2024-03-01T12:30:45.123456789Z id=worker kind=proc stream=stdout " + tc.want + "\n"
+			if buf.String() != want {
+				t.Fatalf("got %q, want %q", buf.String(), want)
+			}
+		})
+	}
+}
+
+func TestWriteDropped(t *testing.T) {
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{FormatText, "-- 12 lines dropped\n"},
+		{FormatJSON, `{"time":"2024-03-01T12:30:45.123456789Z","stream":"event","dropped":12,"line":"12 lines dropped"}` + "\n"},
+		{FormatLogfmt, `time=2024-03-01T12:30:45.123456789Z stream=event dropped=12 line="12 lines dropped"` + "\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.format.String(), func(t *testing.T) {
+			t.Parallel()
+			var buf bytes.Buffer
+			if err := tc.format.WriteDropped(&buf, 12, capturedAt); err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+			if buf.String() != tc.want {
+				t.Fatalf("got %q, want %q", buf.String(), tc.want)
+			}
+		})
+	}
+}
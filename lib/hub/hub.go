@@ -0,0 +1,97 @@
+package hub
+
+import (
+	"sync"
+
+	"github.com/mdsn/gather/lib/source"
+)
+
+// An item delivered to a subscriber: either a record, or a marker for
+// records that were dropped because the subscriber fell behind.
+type Message struct {
+	Out source.Output
+	// Number of records dropped right before this point. Out is unset when
+	// this is nonzero.
+	Dropped int
+}
+
+// A subscriber to the records published on a Hub.
+type Subscription struct {
+	// Glob patterns matched against source ids. Matches everything if empty.
+	patterns []string
+	// Records are delivered here. Closed on Unsubscribe.
+	C chan Message
+	// Records dropped since the last delivery. Guarded by Hub.mu.
+	dropped int
+}
+
+// Whether records from the source with the given id are delivered to s.
+func (s *Subscription) Matches(id string) bool {
+	return source.MatchId(s.patterns, id)
+}
+
+// Fans records out to any number of subscribers. Publishing never blocks: a
+// subscriber whose buffer is full loses records, and is told how many once
+// there is room again.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe to records from sources whose id matches any of patterns,
+// buffering up to size messages.
+func (h *Hub) Subscribe(patterns []string, size int) *Subscription {
+	sub := &Subscription{
+		patterns: patterns,
+		C:        make(chan Message, size),
+	}
+
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+
+	return sub
+}
+
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[sub]; !ok {
+		return
+	}
+	delete(h.subs, sub)
+	close(sub.C)
+}
+
+func (h *Hub) Publish(out source.Output) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		if !sub.Matches(out.Id) {
+			continue
+		}
+
+		// The marker goes first, so the gap shows where it happened.
+		if sub.dropped > 0 {
+			select {
+			case sub.C <- Message{Dropped: sub.dropped}:
+				sub.dropped = 0
+			default:
+				sub.dropped++
+				continue
+			}
+		}
+
+		select {
+		case sub.C <- Message{Out: out}:
+		default:
+			sub.dropped++
+		}
+	}
+}
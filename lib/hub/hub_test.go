@@ -0,0 +1,118 @@
+package hub
+
+import (
+	"testing"
+
+	"github.com/mdsn/gather/lib/source"
+)
+
+func output(id, line string) source.Output {
+	return source.Output{Id: id, Bytes: []byte(line)}
+}
+
+func TestSubscription_Matches(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		id       string
+		want     bool
+	}{
+		{"no-patterns", nil, "syslog", true},
+		{"exact", []string{"syslog"}, "syslog", true},
+		{"exact-miss", []string{"syslog"}, "worker", false},
+		{"glob", []string{"work*"}, "worker-3", true},
+		{"any-of", []string{"syslog", "work*"}, "worker", true},
+		{"glob-stops-at-slash", []string{"app*"}, "app/worker.log", false},
+		{"glob-below-slash", []string{"app/*"}, "app/worker.log", true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			sub := &Subscription{patterns: tc.patterns}
+			if got := sub.Matches(tc.id); got != tc.want {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHub_PublishFiltered(t *testing.T) {
+	h := NewHub()
+	all := h.Subscribe(nil, 4)
+	some := h.Subscribe([]string{"worker"}, 4)
+
+	h.Publish(output("syslog", "one"))
+	h.Publish(output("worker", "two"))
+
+	if len(all.C) != 2 {
+		t.Fatal("wrong number of messages:", len(all.C))
+	}
+	if len(some.C) != 1 {
+		t.Fatal("wrong number of messages:", len(some.C))
+	}
+	if msg := <-some.C; string(msg.Out.Bytes) != "two" {
+		t.Fatalf("unexpected line: '%s'", msg.Out.Bytes)
+	}
+}
+
+func TestHub_DropsWhenFull(t *testing.T) {
+	h := NewHub()
+	sub := h.Subscribe(nil, 2)
+
+	for _, line := range []string{"one", "two", "three", "four"} {
+		h.Publish(output("worker", line))
+	}
+
+	// Make room, then publish once more to flush the marker.
+	<-sub.C
+	<-sub.C
+	h.Publish(output("worker", "five"))
+
+	msg := <-sub.C
+	if msg.Dropped != 2 {
+		t.Fatal("wrong dropped count:", msg.Dropped)
+	}
+	msg = <-sub.C
+	if string(msg.Out.Bytes) != "five" {
+		t.Fatalf("unexpected line: '%s'", msg.Out.Bytes)
+	}
+}
+
+func TestHub_MarkerCountsWhileStillFull(t *testing.T) {
+	h := NewHub()
+	sub := h.Subscribe(nil, 1)
+
+	h.Publish(output("worker", "one"))
+	h.Publish(output("worker", "two"))
+	h.Publish(output("worker", "three"))
+
+	<-sub.C
+	h.Publish(output("worker", "four"))
+	// The marker took the only slot, so "four" counts as dropped too.
+	h.Publish(output("worker", "five"))
+
+	msg := <-sub.C
+	if msg.Dropped != 2 {
+		t.Fatal("wrong dropped count:", msg.Dropped)
+	}
+
+	h.Publish(output("worker", "six"))
+	msg = <-sub.C
+	if msg.Dropped != 2 {
+		t.Fatal("wrong dropped count:", msg.Dropped)
+	}
+}
+
+func TestHub_Unsubscribe(t *testing.T) {
+	h := NewHub()
+	sub := h.Subscribe(nil, 1)
+	h.Unsubscribe(sub)
+	h.Unsubscribe(sub)
+
+	h.Publish(output("worker", "one"))
+
+	if _, ok := <-sub.C; ok {
+		t.Fatal("channel not closed after Unsubscribe")
+	}
+}
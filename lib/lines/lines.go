@@ -59,6 +59,22 @@ func (lb *LineBuffer) Lines() iter.Seq[[]byte] {
 	}
 }
 
+// Take whatever partial line is pending without waiting for its newline,
+// e.g. when the input is switched over to a different file. Returns nil if
+// nothing is pending.
+func (lb *LineBuffer) Flush() []byte {
+	lb.truncating = false
+	if lb.fb.Len() == 0 {
+		return nil
+	}
+	return lb.take()
+}
+
+// Number of bytes of a partial line waiting for its newline.
+func (lb *LineBuffer) Pending() int {
+	return lb.fb.Len()
+}
+
 // Clone the content of the accumulated buffer into a new slice and
 // clear it.
 func (lb *LineBuffer) take() []byte {
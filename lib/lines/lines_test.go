@@ -147,3 +147,59 @@ func TestLineBuffer_AcrossAdd(t *testing.T) {
 		t.Fatal("wrong string, got:", string(lines[0]))
 	}
 }
+
+func TestLineBuffer_Flush(t *testing.T) {
+	lb := NewLineBuffer(40)
+
+	lb.Add([]byte("Brevity is the soul\nof wit"))
+	lines := Collect(lb)
+	if len(lines) != 1 {
+		t.Fatal("wrong length, want 1, got", len(lines))
+	}
+
+	line := lb.Flush()
+	if string(line) != "of wit" {
+		t.Fatalf("wrong flushed line: '%s'", string(line))
+	}
+
+	if line := lb.Flush(); line != nil {
+		t.Fatalf("unexpected second flush: '%s'", string(line))
+	}
+}
+
+func TestLineBuffer_FlushAfterTruncate(t *testing.T) {
+	lb := NewLineBuffer(5)
+
+	lb.Add([]byte("horatio"))
+	Collect(lb)
+
+	if line := lb.Flush(); line != nil {
+		t.Fatalf("unexpected flushed line: '%s'", string(line))
+	}
+
+	// No longer truncating; the next line comes through.
+	lb.Add([]byte("ham\n"))
+	lines := Collect(lb)
+	if len(lines) != 1 || string(lines[0]) != "ham" {
+		t.Fatalf("wrong lines: %q", lines)
+	}
+}
+
+func TestLineBuffer_Pending(t *testing.T) {
+	lb := NewLineBuffer(16)
+	lb.Add([]byte("one\ntw"))
+	for range lb.Lines() {
+	}
+
+	if lb.Pending() != 2 {
+		t.Fatal("wrong pending count:", lb.Pending())
+	}
+
+	lb.Add([]byte("o\n"))
+	for range lb.Lines() {
+	}
+
+	if lb.Pending() != 0 {
+		t.Fatal("wrong pending count:", lb.Pending())
+	}
+}
@@ -0,0 +1,245 @@
+package dir
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mdsn/gather/lib/source"
+	"github.com/mdsn/gather/lib/watch"
+)
+
+// Attaches and detaches the file sources found by a dir source.
+type Children interface {
+	Attach(spec *source.Spec) error
+	Detach(id string) error
+	// Whether the source with the given id is still one attached by the
+	// dir source. It may have been removed or replaced by someone else.
+	Attached(id string) bool
+}
+
+// Attach to a directory. Files in it matching spec.Glob are attached through
+// children as file sources of their own, with ids made of the id of the dir
+// source and the file name, e.g. `app/worker-3.log`. Files in subdirectories,
+// down to spec.MaxDepth, are attached the same way with their relative path,
+// e.g. `build/out/web/link/log.txt`. Files already there start as given by
+// spec.Start; files that show up later are read from the start. Files that
+// are deleted or moved away are detached again.
+func Attach(ctx context.Context, spec *source.Spec, w watch.Watcher, children Children) (*source.Source, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	st, err := os.Stat(spec.Path)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if !st.IsDir() {
+		cancel()
+		return nil, fmt.Errorf("%s: not a directory", spec.Path)
+	}
+
+	d := &dir{
+		spec:     spec,
+		children: children,
+		attached: make(map[string]bool),
+		refused:  make(map[string]bool),
+	}
+	tree, err := watch.AddTree(w, spec.Path, watch.TreeOptions{
+		MaxDepth: spec.MaxDepth,
+		Descend:  func(name string) bool { return !d.excluded(name) },
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	d.src = &source.Source{
+		Id:     spec.Id,
+		Kind:   source.KindDir,
+		Done:   make(chan struct{}),
+		Ready:  make(chan struct{}),
+		Out:    make(chan source.Output),
+		Err:    make(chan error),
+		Cancel: cancel,
+	}
+	go d.watch(ctx, tree)
+
+	return d.src, nil
+}
+
+// The state of a dir source, owned by its goroutine.
+type dir struct {
+	src      *source.Source
+	spec     *source.Spec
+	children Children
+	// Files attached so far, by path relative to the directory. Their
+	// sources may have been removed since; see forget.
+	attached map[string]bool
+	// Files not attached for the limit, reported once.
+	refused map[string]bool
+}
+
+func (d *dir) watch(ctx context.Context, tree *watch.Tree) {
+	defer close(d.src.Done)
+	defer close(d.src.Out)
+	defer tree.Close()
+
+	close(d.src.Ready)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-tree.Out:
+			if !ok || !d.handle(ctx, ev) {
+				return
+			}
+		}
+	}
+}
+
+// Returns false if ctx was done.
+func (d *dir) handle(ctx context.Context, ev watch.TreeEvent) bool {
+	switch {
+	case ev.Op == watch.TreeCreate && ev.Initial:
+		return d.attach(ctx, ev.Name, d.spec.Start)
+	case ev.Op == watch.TreeCreate:
+		// A new file; whatever it holds was written after the source was
+		// attached.
+		return d.attach(ctx, ev.Name, source.Start{Mode: source.StartBeginning})
+	case ev.Dir:
+		// Everything attached below it went with it.
+		for name := range d.refused {
+			if strings.HasPrefix(name, ev.Name+"/") {
+				delete(d.refused, name)
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(d.attached)) {
+			if strings.HasPrefix(name, ev.Name+"/") && !d.detach(ctx, name) {
+				return false
+			}
+		}
+		return true
+	default:
+		return d.detach(ctx, ev.Name)
+	}
+}
+
+// Attach the file with the given relative path, if it is a regular file
+// matching the patterns and the limit allows. Returns false if ctx was done.
+func (d *dir) attach(ctx context.Context, name string, start source.Start) bool {
+	id := d.spec.Id + "/" + name
+	if !d.matches(name) {
+		return true
+	}
+	if d.attached[name] {
+		if d.children.Attached(id) {
+			return true
+		}
+		// Its source was removed or replaced meanwhile.
+		delete(d.attached, name)
+	}
+
+	p := filepath.Join(d.spec.Path, filepath.FromSlash(name))
+	st, err := os.Stat(p)
+	if err != nil || !st.Mode().IsRegular() {
+		return true
+	}
+
+	limit := d.maxFiles()
+	if len(d.attached) >= limit {
+		d.forget()
+	}
+	if len(d.attached) >= limit {
+		if d.refused[name] {
+			return true
+		}
+		d.refused[name] = true
+		return d.src.Event(ctx, fmt.Sprintf("not attaching '%s': limit of %d files reached", id, limit))
+	}
+
+	spec := &source.Spec{
+		Id:       id,
+		Kind:     source.KindFile,
+		Path:     p,
+		Start:    start,
+		StateDir: d.spec.StateDir,
+		Fallback: d.spec.Fallback,
+		Watch:    d.spec.Watch,
+	}
+	if err := d.children.Attach(spec); err != nil {
+		return d.src.Event(ctx, fmt.Sprintf("not attaching '%s': %v", id, err))
+	}
+
+	d.attached[name] = true
+	delete(d.refused, name)
+	return d.src.Event(ctx, fmt.Sprintf("attached '%s'", id))
+}
+
+// Detach the file with the given relative path, if it was attached. Returns
+// false if ctx was done.
+func (d *dir) detach(ctx context.Context, name string) bool {
+	id := d.spec.Id + "/" + name
+	delete(d.refused, name)
+	if !d.attached[name] {
+		return true
+	}
+
+	delete(d.attached, name)
+	if !d.children.Attached(id) {
+		return true
+	}
+	if err := d.children.Detach(id); err != nil {
+		return d.src.Event(ctx, fmt.Sprintf("detaching '%s': %v", id, err))
+	}
+	return d.src.Event(ctx, fmt.Sprintf("detached '%s'", id))
+}
+
+// Forget the files whose sources are gone or were replaced, like with `rm
+// app/x.log`, so that they no longer count toward the limit and are attached
+// again if they show up.
+func (d *dir) forget() {
+	for name := range d.attached {
+		if !d.children.Attached(d.spec.Id + "/" + name) {
+			delete(d.attached, name)
+		}
+	}
+}
+
+// Whether to attach the file with the given relative path.
+func (d *dir) matches(name string) bool {
+	if d.spec.Glob != "" && !match(d.spec.Glob, name) {
+		return false
+	}
+	if len(d.spec.Include) > 0 && !slices.ContainsFunc(d.spec.Include, func(pat string) bool { return match(pat, name) }) {
+		return false
+	}
+	return !d.excluded(name)
+}
+
+// Whether the file or subdirectory with the given relative path is excluded.
+func (d *dir) excluded(name string) bool {
+	return slices.ContainsFunc(d.spec.Exclude, func(pat string) bool { return match(pat, name) })
+}
+
+// Match a pattern without a slash against the last element of name, and one
+// with a slash against all of it.
+func match(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+func (d *dir) maxFiles() int {
+	if d.spec.MaxFiles > 0 {
+		return d.spec.MaxFiles
+	}
+	return source.DefaultMaxFiles
+}
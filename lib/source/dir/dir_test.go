@@ -0,0 +1,393 @@
+package dir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mdsn/gather/lib/source"
+	"github.com/mdsn/gather/lib/watch"
+)
+
+// Records the children attached and detached by a dir source.
+type fakeChildren struct {
+	mu    sync.Mutex
+	specs map[string]*source.Spec
+}
+
+func newFakeChildren() *fakeChildren {
+	return &fakeChildren{specs: make(map[string]*source.Spec)}
+}
+
+func (c *fakeChildren) Attach(spec *source.Spec) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.specs[spec.Id] = spec
+	return nil
+}
+
+func (c *fakeChildren) Detach(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.specs, id)
+	return nil
+}
+
+func (c *fakeChildren) Attached(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.specs[id]
+	return ok
+}
+
+func (c *fakeChildren) spec(id string) *source.Spec {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.specs[id]
+}
+
+func (c *fakeChildren) ids() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids := make([]string, 0, len(c.specs))
+	for id := range c.specs {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// Collect the lifecycle events of src until n of them arrived.
+func events(src *source.Source, n int, deadline time.Duration) ([]string, error) {
+	timeout := time.NewTimer(deadline)
+	defer timeout.Stop()
+
+	var got []string
+	for len(got) < n {
+		select {
+		case out := <-src.Out:
+			got = append(got, string(out.Bytes))
+		case <-timeout.C:
+			return got, errors.New(fmt.Sprintf("timeout; wanted %d events, got %d", n, len(got)))
+		}
+	}
+	return got, nil
+}
+
+func touch(t *testing.T, path string) {
+	t.Helper()
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAttachDir_AttachesAndDetaches(t *testing.T) {
+	ino, err := watch.NewInotify()
+	if err != nil {
+		t.Fatalf("Inotify: %v", err)
+	}
+	defer ino.Close()
+
+	dir := t.TempDir()
+	touch(t, filepath.Join(dir, "worker-1.log"))
+	touch(t, filepath.Join(dir, "notes.txt"))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	children := newFakeChildren()
+	spec := &source.Spec{Id: "app", Kind: source.KindDir, Path: dir, Glob: "*.log"}
+	src, err := Attach(ctx, spec, ino, children)
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+
+	if _, err := events(src, 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got := children.ids(); !slices.Equal(got, []string{"app/worker-1.log"}) {
+		t.Fatalf("unexpected children: %v", got)
+	}
+	// Files already there start where the dir source says.
+	if start := children.spec("app/worker-1.log").Start.Mode; start != source.StartEnd {
+		t.Fatalf("unexpected start: %s", start)
+	}
+
+	touch(t, filepath.Join(dir, "worker-2.log"))
+	touch(t, filepath.Join(dir, "worker-2.txt"))
+	if err := os.Remove(filepath.Join(dir, "worker-1.log")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := events(src, 2, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"attached 'app/worker-2.log'", "detached 'app/worker-1.log'"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("unexpected events: %q", got)
+	}
+	if got := children.ids(); !slices.Equal(got, []string{"app/worker-2.log"}) {
+		t.Fatalf("unexpected children: %v", got)
+	}
+	// New files are read from the start.
+	if start := children.spec("app/worker-2.log").Start.Mode; start != source.StartBeginning {
+		t.Fatalf("unexpected start: %s", start)
+	}
+
+	cancel()
+	select {
+	case <-src.Done:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for src.Done")
+	}
+}
+
+func TestAttachDir_MaxFiles(t *testing.T) {
+	ino, err := watch.NewInotify()
+	if err != nil {
+		t.Fatalf("Inotify: %v", err)
+	}
+	defer ino.Close()
+
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	children := newFakeChildren()
+	spec := &source.Spec{Id: "app", Kind: source.KindDir, Path: dir, MaxFiles: 2}
+	src, err := Attach(ctx, spec, ino, children)
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	<-src.Ready
+
+	for _, name := range []string{"a", "b", "c"} {
+		touch(t, filepath.Join(dir, name))
+	}
+
+	got, err := events(src, 3, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[2] != "not attaching 'app/c': limit of 2 files reached" {
+		t.Fatalf("unexpected events: %q", got)
+	}
+
+	// A file going away makes room for the next one.
+	if err := os.Remove(filepath.Join(dir, "a")); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, filepath.Join(dir, "d"))
+
+	if _, err := events(src, 2, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got := children.ids(); !slices.Equal(got, []string{"app/b", "app/d"}) {
+		t.Fatalf("unexpected children: %v", got)
+	}
+
+	cancel()
+	<-src.Done
+}
+
+func TestAttachDir_ChildRemoved(t *testing.T) {
+	ino, err := watch.NewInotify()
+	if err != nil {
+		t.Fatalf("Inotify: %v", err)
+	}
+	defer ino.Close()
+
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	children := newFakeChildren()
+	spec := &source.Spec{Id: "app", Kind: source.KindDir, Path: dir, MaxFiles: 1}
+	src, err := Attach(ctx, spec, ino, children)
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	<-src.Ready
+
+	touch(t, filepath.Join(dir, "a"))
+	if _, err := events(src, 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removed behind the dir source's back, like with `rm app/a`; it no
+	// longer counts toward the limit.
+	children.Detach("app/a")
+	touch(t, filepath.Join(dir, "b"))
+
+	got, err := events(src, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != "attached 'app/b'" {
+		t.Fatalf("unexpected events: %q", got)
+	}
+
+	// Once gone, a file of the same name is attached again, without a
+	// detach for the source already removed.
+	children.Detach("app/b")
+	if err := os.Remove(filepath.Join(dir, "b")); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, filepath.Join(dir, "b"))
+
+	got, err = events(src, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != "attached 'app/b'" {
+		t.Fatalf("unexpected events: %q", got)
+	}
+	if got := children.ids(); !slices.Equal(got, []string{"app/b"}) {
+		t.Fatalf("unexpected children: %v", got)
+	}
+
+	cancel()
+	<-src.Done
+}
+
+// A directory on a network mount is polled, and its files with it.
+func TestAttachDir_Poll(t *testing.T) {
+	poll := watch.NewPoll()
+	defer poll.Close()
+
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	children := newFakeChildren()
+	spec := &source.Spec{Id: "app", Kind: source.KindDir, Path: dir, Watch: source.WatchPoll}
+	src, err := Attach(ctx, spec, poll, children)
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+	<-src.Ready
+
+	touch(t, filepath.Join(dir, "a.log"))
+	got, err := events(src, 1, 2*watch.PollInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != "attached 'app/a.log'" {
+		t.Fatalf("unexpected events: %q", got)
+	}
+	if child := children.spec("app/a.log"); child.Watch != source.WatchPoll {
+		t.Fatalf("child watched with %s", child.Watch)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "a.log")); err != nil {
+		t.Fatal(err)
+	}
+	got, err = events(src, 1, 2*watch.PollInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != "detached 'app/a.log'" {
+		t.Fatalf("unexpected events: %q", got)
+	}
+
+	cancel()
+	<-src.Done
+}
+
+func TestAttachDir_NotADirectory(t *testing.T) {
+	ino, err := watch.NewInotify()
+	if err != nil {
+		t.Fatalf("Inotify: %v", err)
+	}
+	defer ino.Close()
+
+	path := filepath.Join(t.TempDir(), "file")
+	touch(t, path)
+
+	spec := &source.Spec{Id: "app", Kind: source.KindDir, Path: path}
+	if _, err := Attach(t.Context(), spec, ino, newFakeChildren()); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestAttachDir_Tree(t *testing.T) {
+	ino, err := watch.NewInotify()
+	if err != nil {
+		t.Fatalf("Inotify: %v", err)
+	}
+	defer ino.Close()
+
+	dir := t.TempDir()
+	for _, name := range []string{"top.txt", "web/link/log.txt", "web/tmp/log.txt", "web/link/deep/log.txt"} {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		touch(t, p)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	children := newFakeChildren()
+	spec := &source.Spec{
+		Id:       "build",
+		Kind:     source.KindDir,
+		Path:     dir,
+		MaxDepth: 2,
+		Include:  []string{"*/*/log.txt"},
+		Exclude:  []string{"tmp"},
+	}
+	src, err := Attach(ctx, spec, ino, children)
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+
+	if _, err := events(src, 1, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got := children.ids(); !slices.Equal(got, []string{"build/web/link/log.txt"}) {
+		t.Fatalf("unexpected children: %v", got)
+	}
+
+	// A new directory is watched, and what was written in it right away is
+	// found.
+	p := filepath.Join(dir, "api", "compile", "log.txt")
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, p)
+
+	got, err := events(src, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != "attached 'build/api/compile/log.txt'" {
+		t.Fatalf("unexpected events: %q", got)
+	}
+
+	// Removing a directory detaches everything below it.
+	if err := os.RemoveAll(filepath.Join(dir, "web")); err != nil {
+		t.Fatal(err)
+	}
+	got, err = events(src, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] != "detached 'build/web/link/log.txt'" {
+		t.Fatalf("unexpected events: %q", got)
+	}
+	if got := children.ids(); !slices.Equal(got, []string{"build/api/compile/log.txt"}) {
+		t.Fatalf("unexpected children: %v", got)
+	}
+
+	cancel()
+	<-src.Done
+}
@@ -0,0 +1,59 @@
+package source
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// How a proc source's process ended, along with some of its resource usage.
+type Exit struct {
+	// Exit status. For a process terminated by a signal, 128 plus the signal
+	// number, the way shells report it.
+	Status int
+	// Terminating signal, or 0 if the process exited on its own.
+	Signal syscall.Signal
+	// Wall clock time between start and exit.
+	Runtime time.Duration
+	// Maximum resident set size, in bytes.
+	MaxRSS int64
+	// CPU time spent in user and kernel mode.
+	UserTime time.Duration
+	SysTime  time.Duration
+}
+
+// Summarize the exit, e.g. `status=137 signal=KILL after 3m12s`, followed by
+// resource usage.
+func (e *Exit) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "status=%d", e.Status)
+	if e.Signal != 0 {
+		fmt.Fprintf(&sb, " signal=%s", SignalName(e.Signal))
+	}
+	fmt.Fprintf(&sb, " after %s", roundDuration(e.Runtime))
+	fmt.Fprintf(&sb, " maxrss=%dk user=%s sys=%s",
+		e.MaxRSS/1024,
+		roundDuration(e.UserTime),
+		roundDuration(e.SysTime))
+	return sb.String()
+}
+
+// The name of a signal without its SIG prefix, e.g. KILL.
+func SignalName(sig syscall.Signal) string {
+	name := unix.SignalName(sig)
+	if name == "" {
+		return fmt.Sprintf("%d", int(sig))
+	}
+	return strings.TrimPrefix(name, "SIG")
+}
+
+// Keep durations readable: milliseconds under a minute, seconds above.
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Minute {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(time.Second)
+}
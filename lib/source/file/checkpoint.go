@@ -0,0 +1,173 @@
+package file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+var (
+	checkpointInterval = 5 * time.Second
+)
+
+// Number of bytes at the start of a file hashed into its checkpoint. Tells a
+// file apart from a later one that reused its inode.
+const headSize = 1024
+
+// The position of a file source in the file it reads, and enough about the
+// file to recognize it again.
+type checkpoint struct {
+	Dev    uint64 `json:"dev"`
+	Ino    uint64 `json:"ino"`
+	Offset int64  `json:"offset"`
+	// Length and SHA-256 of the first bytes of the file.
+	HeadLen  int       `json:"head_len"`
+	HeadHash string    `json:"head_sha256"`
+	SavedAt  time.Time `json:"saved_at"`
+}
+
+func makeCheckpoint(fp *os.File, offset int64) (*checkpoint, error) {
+	stat, err := fp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	st, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, errors.New("no stat_t for file")
+	}
+
+	headLen := int(min(stat.Size(), headSize))
+	hash, err := headHash(fp, headLen)
+	if err != nil {
+		return nil, err
+	}
+
+	return &checkpoint{
+		Dev:      st.Dev,
+		Ino:      st.Ino,
+		Offset:   offset,
+		HeadLen:  headLen,
+		HeadHash: hash,
+		SavedAt:  time.Now(),
+	}, nil
+}
+
+// Whether fp is the file the checkpoint was taken from, and still reaches
+// its offset.
+func (cp *checkpoint) matches(fp *os.File) (bool, error) {
+	now, err := makeCheckpoint(fp, cp.Offset)
+	if err != nil {
+		return false, err
+	}
+	if now.Dev != cp.Dev || now.Ino != cp.Ino {
+		return false, nil
+	}
+
+	size, err := fileSize(fp)
+	if err != nil {
+		return false, err
+	}
+	if size < cp.Offset || size < int64(cp.HeadLen) {
+		return false, nil
+	}
+
+	hash, err := headHash(fp, cp.HeadLen)
+	if err != nil {
+		return false, err
+	}
+	return hash == cp.HeadHash, nil
+}
+
+func headHash(fp *os.File, n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := fp.ReadAt(buf, 0); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Loads and saves the checkpoint of a single source. A nil *checkpoints
+// means checkpointing is disabled, and its methods do nothing.
+type checkpoints struct {
+	path string
+	// The last checkpoint saved, to skip saving it again.
+	last checkpoint
+}
+
+// Checkpoints for the source with the given id, kept in dir. Returns nil if
+// dir is empty.
+func openCheckpoints(dir, id string) *checkpoints {
+	if dir == "" {
+		return nil
+	}
+	// Ids may contain slashes.
+	return &checkpoints{path: filepath.Join(dir, url.PathEscape(id)+".json")}
+}
+
+// The saved checkpoint, or nil if there is none.
+func (c *checkpoints) load() (*checkpoint, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(c.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cp checkpoint
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// Save the position of r, unless it has not moved since the last save.
+func (c *checkpoints) save(r *reader) error {
+	if c == nil {
+		return nil
+	}
+
+	cp, err := makeCheckpoint(r.fp, r.position())
+	if err != nil {
+		return err
+	}
+	if cp.Dev == c.last.Dev && cp.Ino == c.last.Ino && cp.Offset == c.last.Offset &&
+		cp.HeadLen == c.last.HeadLen {
+		return nil
+	}
+
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return err
+	}
+
+	// Replace the old checkpoint atomically; a crash mid-write must not
+	// leave a torn one behind.
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return err
+	}
+
+	c.last = *cp
+	return nil
+}
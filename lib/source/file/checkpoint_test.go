@@ -0,0 +1,165 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mdsn/gather/lib/source"
+)
+
+// Write content to a new file in dir and open it for reading.
+func openContent(t *testing.T, path, content string) *os.File {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	fp, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { fp.Close() })
+	return fp
+}
+
+func TestCheckpoints_Disabled(t *testing.T) {
+	cps := openCheckpoints("", "syslog")
+	if cps != nil {
+		t.Fatal("expected nil checkpoints")
+	}
+
+	cp, err := cps.load()
+	if cp != nil || err != nil {
+		t.Fatalf("unexpected load result: %v %v", cp, err)
+	}
+	if err := cps.save(nil); err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+}
+
+func TestCheckpoints_SaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	fp := openContent(t, dir+"/log", "one\ntwo\nthr")
+
+	cps := openCheckpoints(dir+"/state", "app/worker")
+	r := newReader(fp, 11)
+	r.lb.Add([]byte("thr"))
+	for range r.lb.Lines() {
+	}
+
+	if err := cps.save(r); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	// Slashes in the id do not make for subdirectories.
+	if _, err := os.Stat(filepath.Join(dir, "state", "app%2Fworker.json")); err != nil {
+		t.Fatalf("checkpoint file: %v", err)
+	}
+
+	cp, err := openCheckpoints(dir+"/state", "app/worker").load()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	// The partial line is read again on resume.
+	if cp.Offset != 8 {
+		t.Fatal("wrong offset:", cp.Offset)
+	}
+	if cp.HeadLen != 11 {
+		t.Fatal("wrong head length:", cp.HeadLen)
+	}
+
+	ok, err := cp.matches(fp)
+	if err != nil || !ok {
+		t.Fatalf("checkpoint does not match its own file: %v", err)
+	}
+}
+
+func TestCheckpoint_Mismatch(t *testing.T) {
+	dir := t.TempDir()
+	fp := openContent(t, dir+"/log", "one\ntwo\n")
+	cp, err := makeCheckpoint(fp, 8)
+	if err != nil {
+		t.Fatalf("makeCheckpoint: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(path string)
+	}{
+		// Same inode, different contents.
+		{"rewritten", func(path string) { os.WriteFile(path, []byte("uno\ndos\ntres\n"), 0600) }},
+		{"truncated", func(path string) { os.Truncate(path, 4) }},
+		// Same contents, different inode.
+		{"replaced", func(path string) {
+			os.Remove(path)
+			os.WriteFile(path, []byte("one\ntwo\n"), 0600)
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := t.TempDir() + "/log"
+			if err := os.Link(dir+"/log", path); err != nil {
+				t.Fatalf("Link: %v", err)
+			}
+			tc.modify(path)
+
+			fp, err := os.Open(path)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer fp.Close()
+
+			if ok, _ := cp.matches(fp); ok {
+				t.Fatal("checkpoint matches a different file")
+			}
+
+			// Put the original back for the next case.
+			os.WriteFile(dir+"/log", []byte("one\ntwo\n"), 0600)
+		})
+	}
+}
+
+func TestResumeOffset(t *testing.T) {
+	dir := t.TempDir()
+	content := "one\ntwo\nthree\n"
+	fp := openContent(t, dir+"/log", content)
+
+	cps := openCheckpoints(dir+"/state", "log")
+	if err := cps.save(newReader(fp, 4)); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	other := openContent(t, dir+"/other", strings.Repeat("x\n", 10))
+
+	tests := []struct {
+		name string
+		fp   *os.File
+		spec source.Spec
+		want int64
+	}{
+		{"match", fp, source.Spec{}, 4},
+		{"match-ignores-start", fp, source.Spec{Start: source.Start{Mode: source.StartBeginning}}, 4},
+		{"fallback-from", other, source.Spec{Start: source.Start{Mode: source.StartLines, Lines: 1}}, 18},
+		{"fallback-start", other, source.Spec{Fallback: source.FallbackBeginning}, 0},
+		{"fallback-end", other, source.Spec{Fallback: source.FallbackEnd}, 20},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			offset, err := resumeOffset(tc.fp, &tc.spec, cps)
+			if err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+			if offset != tc.want {
+				t.Fatal("wrong offset:", offset)
+			}
+		})
+	}
+
+	// Without a checkpoint, the starting point is used.
+	offset, err := resumeOffset(fp, &source.Spec{}, openCheckpoints(dir+"/state", "new"))
+	if err != nil || offset != int64(len(content)) {
+		t.Fatalf("unexpected offset %d: %v", offset, err)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"io"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/mdsn/gather/lib/lines"
 	"github.com/mdsn/gather/lib/source"
@@ -18,16 +20,8 @@ func fileSize(fp *os.File) (int64, error) {
 	return stat.Size(), nil
 }
 
-func Attach(ctx context.Context, spec *source.Spec, handle *watch.WatchHandle) (*source.Source, error) {
-	ctx, cancel := context.WithCancel(ctx)
-	fp, err := os.OpenFile(spec.Path, os.O_RDONLY, 0)
-	if err != nil {
-		// XXX close handle.Out?
-		return nil, err
-	}
-
-	// TODO source.NewSource(...)
-	src := &source.Source{
+func newSource(spec *source.Spec, cancel context.CancelFunc) *source.Source {
+	return &source.Source{
 		Id:     spec.Id,
 		Kind:   source.KindFile,
 		Done:   make(chan struct{}),
@@ -35,75 +29,395 @@ func Attach(ctx context.Context, spec *source.Spec, handle *watch.WatchHandle) (
 		Out:    make(chan source.Output),
 		Err:    make(chan error),
 		Cancel: cancel,
+		// Received between reads, so it may wait for the rest of a burst.
+		Control: make(chan source.Control),
+	}
+}
+
+// Attach to a file by descriptor: it is followed wherever it is moved to,
+// until the source is cancelled. The watch is added to w and removed once the
+// source is done.
+func Attach(ctx context.Context, spec *source.Spec, w watch.Watcher) (*source.Source, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	handle, err := w.Add(spec.Path)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	fp, err := os.OpenFile(spec.Path, os.O_RDONLY, 0)
+	if err != nil {
+		cancel()
+		w.Rm(handle)
+		return nil, err
 	}
 
-	go tail(ctx, src, fp, handle.Out)
+	// TODO source.NewSource(...)
+	src := newSource(spec, cancel)
+
+	go tail(ctx, src, w, spec, fp, handle)
 
 	return src, nil
 }
 
-func tail(ctx context.Context, src *source.Source, fp *os.File, evC chan watch.Event) {
+// Attach to a file by name, like `tail -F`. Besides the file itself, its
+// parent directory is watched for files created or moved into place under
+// the same name. When that happens the remainder of the old file is read and
+// the new one is followed from the start. Watches are added to and removed
+// from w as the file is replaced.
+func AttachName(ctx context.Context, spec *source.Spec, w watch.Watcher) (*source.Source, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	dir, err := w.Add(filepath.Dir(spec.Path))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	handle, err := w.Add(spec.Path)
+	if err != nil {
+		cancel()
+		w.Rm(dir)
+		return nil, err
+	}
+
+	fp, err := os.OpenFile(spec.Path, os.O_RDONLY, 0)
+	if err != nil {
+		cancel()
+		w.Rm(handle)
+		w.Rm(dir)
+		return nil, err
+	}
+
+	src := newSource(spec, cancel)
+
+	go followName(ctx, src, w, spec, fp, handle, dir)
+
+	return src, nil
+}
+
+// Reads a file from an offset up to EOF and turns it into lines.
+type reader struct {
+	fp     *os.File
+	offset int64
+	lb     *lines.LineBuffer
+	buf    []byte
+}
+
+func newReader(fp *os.File, offset int64) *reader {
+	return &reader{
+		fp:     fp,
+		offset: offset,
+		lb:     lines.NewLineBuffer(source.MaxLineLength),
+		buf:    make([]byte, 4096),
+	}
+}
+
+// The offset of the first byte not yet sent as part of a line. A partial line
+// is still pending at the reader's offset.
+func (r *reader) position() int64 {
+	return r.offset - int64(r.lb.Pending())
+}
+
+// Read whatever was appended to the file since the last call and send every
+// complete line to src.
+func (r *reader) update(ctx context.Context, src *source.Source) error {
+	sz, err := fileSize(r.fp)
+	if err != nil {
+		return err
+	}
+
+	// File was truncated, bring offset back
+	if sz < r.offset {
+		r.offset = sz
+		return nil
+	}
+
+	// read file from offset
+	_, err = r.fp.Seek(r.offset, 0)
+	if err != nil {
+		return err
+	}
+
+	for {
+		buf := r.buf[:cap(r.buf)]
+
+		// TODO pread(2)?
+		n, err := r.fp.Read(buf)
+		if n == 0 && err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		r.offset += int64(n)
+
+		r.lb.Add(buf[:n])
+		for line := range r.lb.Lines() {
+			if !src.Send(ctx, line) {
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// Jump to EOF, dropping whatever partial line is pending.
+func (r *reader) skip() error {
+	sz, err := fileSize(r.fp)
+	if err != nil {
+		return err
+	}
+	r.offset = sz
+	r.lb.Flush()
+	return nil
+}
+
+func tail(
+	ctx context.Context,
+	src *source.Source,
+	w watch.Watcher,
+	spec *source.Spec,
+	fp *os.File,
+	handle *watch.WatchHandle,
+) {
 	defer close(src.Done)
+	defer close(src.Out)
+	defer func() {
+		w.Rm(handle)
+		fp.Close()
+	}()
 
-	// Start at EOF
-	offset, err := fileSize(fp)
+	cps := openCheckpoints(spec.StateDir, spec.Id)
+	offset, err := resumeOffset(fp, spec, cps)
 	if err != nil {
 		return // XXX ?
 	}
 
-	lb := lines.NewLineBuffer(4096 * 2)
-	buf := make([]byte, 4096)
+	r := newReader(fp, offset)
+	// Save the final position, however the source ends. Runs before the
+	// deferred cleanup closes fp.
+	defer cps.save(r) // XXX src.Err
+	tick := checkpointTicker(cps)
+	defer tick.Stop()
 
 	// Start listening
 	close(src.Ready)
 
-outer:
+	// Emit whatever is already there past the starting point.
+	if err := r.update(ctx, src); err != nil {
+		return
+	}
+
+	paused := false
 	for {
 		select {
 		case <-ctx.Done():
-			break outer
-		case <-evC:
-			// Continue
+			return
+		case <-tick.C:
+			_ = cps.save(r) // XXX src.Err
+			continue
+		case c := <-src.Control:
+			paused = c.Pause
+			if paused {
+				continue
+			}
+			if c.Skip {
+				if err := r.skip(); err != nil {
+					return
+				}
+			}
+		case _, ok := <-handle.Out:
+			// The watcher was closed.
+			if !ok {
+				return
+			}
+			// Events are still read while paused, or they would hold up
+			// every other watch. The offset stays where it was. Whatever
+			// the event, a truncation or lost events included, the update
+			// reads up to the current size.
+			if paused {
+				continue
+			}
 		}
 
-		sz, err := fileSize(fp)
-		if err != nil {
+		if err := r.update(ctx, src); err != nil {
 			// XXX src.Err
 			return
 		}
+	}
+}
+
+func followName(
+	ctx context.Context,
+	src *source.Source,
+	w watch.Watcher,
+	spec *source.Spec,
+	fp *os.File,
+	handle *watch.WatchHandle,
+	dir *watch.WatchHandle,
+) {
+	defer close(src.Done)
+	defer close(src.Out)
+	defer func() {
+		// handle is replaced on every rotation; remove the latest one.
+		w.Rm(handle)
+		w.Rm(dir)
+		fp.Close()
+	}()
+
+	cps := openCheckpoints(spec.StateDir, spec.Id)
+	offset, err := resumeOffset(fp, spec, cps)
+	if err != nil {
+		return // XXX ?
+	}
+
+	r := newReader(fp, offset)
+	// Runs before the deferred cleanup closes fp.
+	defer cps.save(r) // XXX src.Err
+	tick := checkpointTicker(cps)
+	defer tick.Stop()
+
+	path := spec.Path
+	base := filepath.Base(path)
 
-		// File was truncated, bring offset back
-		if sz < offset {
-			offset = sz
+	// Start listening
+	close(src.Ready)
+
+	// Emit whatever is already there past the starting point.
+	if err := r.update(ctx, src); err != nil {
+		return
+	}
+
+	// A file that took the place of ours while paused is only looked at
+	// once resumed.
+	paused, moved := false, false
+	for {
+		skip := false
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			_ = cps.save(r) // XXX src.Err
 			continue
+		case c := <-src.Control:
+			paused = c.Pause
+			if paused {
+				continue
+			}
+			skip = c.Skip
+			if skip {
+				if err := r.skip(); err != nil {
+					return
+				}
+			}
+			if err := r.update(ctx, src); err != nil {
+				return
+			}
+			if !moved {
+				continue
+			}
+			moved = false
+		case ev, ok := <-handle.Out:
+			if !ok {
+				return
+			}
+			if paused {
+				moved = moved || ev.Kind == watch.EventOverflow
+				continue
+			}
+			if err := r.update(ctx, src); err != nil {
+				// XXX src.Err
+				return
+			}
+			// Events were lost, the file may have been replaced as well.
+			if ev.Kind != watch.EventOverflow {
+				continue
+			}
+		case ev, ok := <-dir.Out:
+			if !ok {
+				return
+			}
+			// Only something appearing under our name, or lost events.
+			lost := ev.Kind == watch.EventOverflow
+			if !lost && (ev.Name != base || !ev.Appeared()) {
+				continue
+			}
+			if paused {
+				moved = true
+				continue
+			}
 		}
 
-		// read file from offset
-		_, err = fp.Seek(offset, 0)
+		// Something appeared at path. It may not be there anymore, or it
+		// may be the file we already have open.
+		next, err := os.OpenFile(path, os.O_RDONLY, 0)
+		if err != nil {
+			continue
+		}
+		if same, err := sameFile(fp, next); err != nil || same {
+			next.Close()
+			continue
+		}
+
+		// Drain the old file. Its last line may lack a newline; emit it
+		// anyway rather than gluing it to the first line of the new file.
+		if err := r.update(ctx, src); err != nil {
+			next.Close()
+			return
+		}
+		if line := r.lb.Flush(); line != nil && !src.Send(ctx, line) {
+			next.Close()
+			return
+		}
+
+		nextHandle, err := w.Add(path)
 		if err != nil {
 			// XXX src.Err
+			next.Close()
 			return
 		}
 
-		for {
-			buf = buf[:cap(buf)]
+		w.Rm(handle)
+		fp.Close()
+		fp = next
+		handle = nextHandle
 
-			// TODO pread(2)?
-			n, err := fp.Read(buf)
-			if n == 0 && err == io.EOF {
-				break
-			}
-			if err != nil {
-				// XXX src.Err
+		// Follow the new file from the start, or from its end when
+		// skipping what was written while paused.
+		r.fp = fp
+		r.offset = 0
+		if skip {
+			if err := r.skip(); err != nil {
 				return
 			}
+		}
+		if err := r.update(ctx, src); err != nil {
+			return
+		}
+		_ = cps.save(r) // XXX src.Err
+	}
+}
 
-			offset += int64(n)
+// A ticker for periodic checkpoints. It never fires if checkpointing is
+// disabled.
+func checkpointTicker(cps *checkpoints) *time.Ticker {
+	tick := time.NewTicker(checkpointInterval)
+	if cps == nil {
+		tick.Stop()
+	}
+	return tick
+}
 
-			lb.Add(buf)
-			for line := range lb.Lines() {
-				src.Send(line)
-			}
-		}
+func sameFile(a, b *os.File) (bool, error) {
+	sa, err := a.Stat()
+	if err != nil {
+		return false, err
+	}
+	sb, err := b.Stat()
+	if err != nil {
+		return false, err
 	}
+	return os.SameFile(sa, sb), nil
 }
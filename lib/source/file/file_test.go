@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -88,12 +89,7 @@ func TestAttachFile_OutputLines(t *testing.T) {
 	ctx, cancel := context.WithCancel(t.Context())
 	t.Cleanup(cancel)
 
-	handle, err := ino.Add(spec.Path)
-	if err != nil {
-		t.Fatalf("inotify Add: %v", err)
-	}
-
-	src, err := Attach(ctx, spec, handle)
+	src, err := Attach(ctx, spec, ino)
 	if err != nil {
 		t.Fatalf("Attach: %v", err)
 	}
@@ -152,22 +148,12 @@ func TestAttachFile_MultipleFiles(t *testing.T) {
 	ctx, cancel := context.WithCancel(t.Context())
 	t.Cleanup(cancel)
 
-	handle1, err := ino.Add(spec1.Path)
-	if err != nil {
-		t.Fatalf("inotify Add: %v", err)
-	}
-
-	handle2, err := ino.Add(spec2.Path)
-	if err != nil {
-		t.Fatalf("inotify Add: %v", err)
-	}
-
-	src1, err := Attach(ctx, spec1, handle1)
+	src1, err := Attach(ctx, spec1, ino)
 	if err != nil {
 		t.Fatalf("Attach: %v", err)
 	}
 
-	src2, err := Attach(ctx, spec2, handle2)
+	src2, err := Attach(ctx, spec2, ino)
 	if err != nil {
 		t.Fatalf("Attach: %v", err)
 	}
@@ -254,12 +240,7 @@ func TestAttachFile_TruncateFile(t *testing.T) {
 	ctx, cancel := context.WithCancel(t.Context())
 	t.Cleanup(cancel)
 
-	handle, err := ino.Add(spec.Path)
-	if err != nil {
-		t.Fatalf("inotify Add: %v", err)
-	}
-
-	src, err := Attach(ctx, spec, handle)
+	src, err := Attach(ctx, spec, ino)
 	if err != nil {
 		t.Fatalf("Attach: %v", err)
 	}
@@ -341,12 +322,7 @@ func TestAttachFile_TruncatePastEOF(t *testing.T) {
 	ctx, cancel := context.WithCancel(t.Context())
 	t.Cleanup(cancel)
 
-	handle, err := ino.Add(spec.Path)
-	if err != nil {
-		t.Fatalf("inotify Add: %v", err)
-	}
-
-	src, err := Attach(ctx, spec, handle)
+	src, err := Attach(ctx, spec, ino)
 	if err != nil {
 		t.Fatalf("Attach: %v", err)
 	}
@@ -402,15 +378,12 @@ func TestAttachFile_TruncatePastEOF(t *testing.T) {
 
 // Test ino.Add -> rm file -> Attach (race situation)
 // Inotify does not open a descriptor to the inode, so (assuming no other
-// process has an open descriptor to it) the OS can destroy it. Attaching will
-// try to open the path and fail. Depending on timing the OS might deliver
-// IN_IGNORED to inotify, due to the file being unlinked.
+// process has an open descriptor to it) the OS can destroy it between adding
+// the watch and opening the file. Attaching fails, and the watch is removed.
+// The fake watcher stands in for inotify, which would refuse a missing path.
 func TestAttachFile_RmBeforeAttach(t *testing.T) {
-	ino, err := watch.NewInotify()
-	if err != nil {
-		t.Fatalf("Inotify: %v", err)
-	}
-	defer ino.Close()
+	fake := watch.NewFake()
+	defer fake.Close()
 
 	// Create a tmp file
 	tmp, spec, err := MakeSpec("rm-before-attach")
@@ -419,12 +392,6 @@ func TestAttachFile_RmBeforeAttach(t *testing.T) {
 	}
 	defer os.Remove(spec.Path)
 
-	// Add a watch on inotify
-	handle, err := ino.Add(spec.Path)
-	if err != nil {
-		t.Fatalf("inotify Add: %v", err)
-	}
-
 	// Close the file and remove it
 	err = tmp.Close()
 	if err != nil {
@@ -435,15 +402,483 @@ func TestAttachFile_RmBeforeAttach(t *testing.T) {
 		t.Fatalf("remove: %v", err)
 	}
 
-	// Verify the file does not exist
-	_, err = os.OpenFile(spec.Path, os.O_RDONLY, 0600)
-	if err == nil {
-		t.Fatalf("expected file %s not to exist", spec.Path)
-	}
-
 	// Try to attach, see it fail.
-	_, err = Attach(t.Context(), spec, handle)
+	_, err = Attach(t.Context(), spec, fake)
 	if err == nil {
 		t.Fatalf("Expected an error on Attach")
 	}
+	if n := fake.Watching(spec.Path); n != 0 {
+		t.Fatalf("%d watches left", n)
+	}
+}
+
+// The poller may have backed off by the time something changes.
+var watchers = []struct {
+	name     string
+	watcher  func(t *testing.T) watch.Watcher
+	deadline time.Duration
+}{
+	{"inotify", func(t *testing.T) watch.Watcher {
+		ino, err := watch.NewInotify()
+		if err != nil {
+			t.Fatalf("Inotify: %v", err)
+		}
+		t.Cleanup(func() { ino.Close() })
+		return ino
+	}, time.Second},
+	{"poll", func(t *testing.T) watch.Watcher {
+		p := watch.NewPoll()
+		t.Cleanup(func() { p.Close() })
+		return p
+	}, 2 * watch.PollMaxInterval},
+}
+
+func TestAttachName_FollowsRotation(t *testing.T) {
+	for _, tc := range watchers {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			w, deadline := tc.watcher(t), tc.deadline
+
+			dir := t.TempDir()
+			path := dir + "/app.log"
+			old, err := os.Create(path)
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			defer old.Close()
+
+			spec := &source.Spec{Id: "rotate", Kind: source.KindFile, Path: path, Follow: source.FollowName}
+
+			ctx, cancel := context.WithCancel(t.Context())
+			t.Cleanup(cancel)
+
+			src, err := AttachName(ctx, spec, w)
+			if err != nil {
+				t.Fatalf("AttachName: %v", err)
+			}
+
+			lineC := make(chan []byte, 16)
+			go consume(ctx, src, lineC)
+			<-src.Ready
+
+			line1 := "Whan that Aprill with his shoures soote"
+			if _, err := write(old, []byte(line1+"\n")); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := collect(1, lineC, deadline); err != nil {
+				t.Fatalf("collect: %v", err)
+			}
+
+			// Rotate: move the file away, keep writing to it, then put a new file
+			// in its place. The last line of the old file has no newline.
+			if err := os.Rename(path, path+".1"); err != nil {
+				t.Fatalf("Rename: %v", err)
+			}
+
+			line2 := "The droghte of March hath perced to the roote"
+			if _, err := write(old, []byte(line2)); err != nil {
+				t.Fatal(err)
+			}
+
+			next, err := os.Create(path)
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			defer next.Close()
+
+			line3 := "And bathed every veyne in swich licour"
+			if _, err := write(next, []byte(line3+"\n")); err != nil {
+				t.Fatal(err)
+			}
+
+			lines, err := collect(2, lineC, deadline)
+			if err != nil {
+				t.Fatalf("collect: %v", err)
+			}
+
+			if string(lines[0]) != line2 || string(lines[1]) != line3 {
+				t.Fatalf("unexpected output: '%s' '%s'", lines[0], lines[1])
+			}
+
+			// The new file keeps being followed.
+			line4 := "Of which vertu engendred is the flour"
+			if _, err := write(next, []byte(line4+"\n")); err != nil {
+				t.Fatal(err)
+			}
+
+			lines, err = collect(1, lineC, deadline)
+			if err != nil {
+				t.Fatalf("collect: %v", err)
+			}
+
+			if string(lines[0]) != line4 {
+				t.Fatalf("unexpected output: '%s'", lines[0])
+			}
+
+			cancel()
+
+			if err := wait(src, deadline); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// With the fake watcher, the file is read exactly when an event says so.
+func TestAttachFile_FakeEvents(t *testing.T) {
+	fake := watch.NewFake()
+	defer fake.Close()
+
+	tmp, spec, err := MakeSpec("fake")
+	if err != nil {
+		t.Fatalf("MakeSpec: %v", err)
+	}
+	defer os.Remove(spec.Path)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+
+	src, err := Attach(ctx, spec, fake)
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+
+	lineC := make(chan []byte, 16)
+	go consume(ctx, src, lineC)
+	<-src.Ready
+
+	if _, err := write(tmp, []byte("one\ntwo\n")); err != nil {
+		t.Fatal(err)
+	}
+	if lines, err := collect(1, lineC, 50*time.Millisecond); err == nil {
+		t.Fatalf("read without an event: '%s'", lines[0])
+	}
+
+	if n := fake.Notify(spec.Path, watch.Event{Kind: watch.EventModify}); n != 1 {
+		t.Fatalf("event received by %d watches", n)
+	}
+	lines, err := collect(2, lineC, time.Second)
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if string(lines[0]) != "one" || string(lines[1]) != "two" {
+		t.Fatalf("unexpected output: '%s' '%s'", lines[0], lines[1])
+	}
+
+	// Notify returns once the event is received, so the second one is only
+	// taken after the truncation was dealt with.
+	if err := tmp.Truncate(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmp.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	fake.Notify(spec.Path, watch.Event{Kind: watch.EventTruncate})
+	fake.Notify(spec.Path, watch.Event{Kind: watch.EventModify})
+	if _, err := write(tmp, []byte("three\n")); err != nil {
+		t.Fatal(err)
+	}
+	fake.Notify(spec.Path, watch.Event{Kind: watch.EventModify})
+
+	lines, err = collect(1, lineC, time.Second)
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if string(lines[0]) != "three" {
+		t.Fatalf("unexpected line: '%s'", lines[0])
+	}
+
+	// Lost events are caught up on like any other.
+	if _, err := write(tmp, []byte("four\n")); err != nil {
+		t.Fatal(err)
+	}
+	fake.Overflow()
+	lines, err = collect(1, lineC, time.Second)
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if string(lines[0]) != "four" {
+		t.Fatalf("unexpected line: '%s'", lines[0])
+	}
+
+	cancel()
+	if err := wait(src, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if n := fake.Watching(spec.Path); n != 0 {
+		t.Fatalf("%d watches left", n)
+	}
+}
+
+// A rotation whose directory event was lost is found after an overflow.
+func TestAttachName_Overflow(t *testing.T) {
+	fake := watch.NewFake()
+	defer fake.Close()
+
+	dir := t.TempDir()
+	path := dir + "/app.log"
+	old, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer old.Close()
+
+	spec := &source.Spec{Id: "overflow", Kind: source.KindFile, Path: path, Follow: source.FollowName}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+
+	src, err := AttachName(ctx, spec, fake)
+	if err != nil {
+		t.Fatalf("AttachName: %v", err)
+	}
+
+	lineC := make(chan []byte, 16)
+	go consume(ctx, src, lineC)
+	<-src.Ready
+
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if _, err := write(old, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	next, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	defer next.Close()
+	if _, err := write(next, []byte("new\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Names leaving the directory are of no interest.
+	fake.Notify(dir, watch.Event{Kind: watch.EventMoveOut, Name: "app.log"})
+	if lines, err := collect(1, lineC, 50*time.Millisecond); err == nil {
+		t.Fatalf("read without an event: '%s'", lines[0])
+	}
+
+	fake.Overflow()
+	lines, err := collect(2, lineC, time.Second)
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if string(lines[0]) != "old" || string(lines[1]) != "new" {
+		t.Fatalf("unexpected output: '%s' '%s'", lines[0], lines[1])
+	}
+
+	// The old file is no longer watched, the new one is.
+	if n := fake.Watching(path); n != 1 {
+		t.Fatalf("%d watches on the file", n)
+	}
+	if _, err := write(next, []byte("newer\n")); err != nil {
+		t.Fatal(err)
+	}
+	fake.Notify(path, watch.Event{Kind: watch.EventModify})
+	lines, err = collect(1, lineC, time.Second)
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if string(lines[0]) != "newer" {
+		t.Fatalf("unexpected line: '%s'", lines[0])
+	}
+
+	cancel()
+	if err := wait(src, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if n := fake.Watching(path) + fake.Watching(dir); n != 0 {
+		t.Fatalf("%d watches left", n)
+	}
+}
+
+func TestAttachFile_StartLines(t *testing.T) {
+	ino, err := watch.NewInotify()
+	if err != nil {
+		t.Fatalf("Inotify: %v", err)
+	}
+	defer ino.Close()
+
+	tmp, spec, err := MakeSpec("start-lines")
+	if err != nil {
+		t.Fatalf("MakeSpec: %v", err)
+	}
+	defer os.Remove(spec.Path)
+
+	// The backlog is truncated like any other line.
+	long := strings.Repeat("x", source.MaxLineLength+10)
+	if _, err := write(tmp, []byte("one\ntwo\n"+long+"\nfour\n")); err != nil {
+		t.Fatal(err)
+	}
+	spec.Start = source.Start{Mode: source.StartLines, Lines: 2}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+
+	src, err := Attach(ctx, spec, ino)
+	if err != nil {
+		t.Fatalf("Attach: %v", err)
+	}
+
+	lineC := make(chan []byte, 16)
+	go consume(ctx, src, lineC)
+
+	// Lines from the backlog arrive without any further writes.
+	lines, err := collect(2, lineC, time.Second)
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+
+	if string(lines[0]) != long[:source.MaxLineLength] {
+		t.Fatal("backlog line not truncated, length", len(lines[0]))
+	}
+	if string(lines[1]) != "four" {
+		t.Fatalf("unexpected line: '%s'", lines[1])
+	}
+
+	if _, err := write(tmp, []byte("five\n")); err != nil {
+		t.Fatal(err)
+	}
+	lines, err = collect(1, lineC, time.Second)
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if string(lines[0]) != "five" {
+		t.Fatalf("unexpected line: '%s'", lines[0])
+	}
+}
+
+func TestAttachFile_ResumesFromCheckpoint(t *testing.T) {
+	ino, err := watch.NewInotify()
+	if err != nil {
+		t.Fatalf("Inotify: %v", err)
+	}
+	defer ino.Close()
+
+	tmp, spec, err := MakeSpec("resume")
+	if err != nil {
+		t.Fatalf("MakeSpec: %v", err)
+	}
+	defer os.Remove(spec.Path)
+	spec.StateDir = t.TempDir()
+
+	attach := func(ctx context.Context) (*source.Source, chan []byte) {
+		src, err := Attach(ctx, spec, ino)
+		if err != nil {
+			t.Fatalf("Attach: %v", err)
+		}
+		lineC := make(chan []byte, 16)
+		go consume(ctx, src, lineC)
+		<-src.Ready
+		return src, lineC
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	src, lineC := attach(ctx)
+
+	if _, err := write(tmp, []byte("one\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := collect(1, lineC, time.Second); err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+
+	// The final checkpoint is saved as the source stops.
+	cancel()
+	if err := wait(src, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Written while nobody was looking.
+	if _, err := write(tmp, []byte("two\nthree\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel = context.WithCancel(t.Context())
+	src, lineC = attach(ctx)
+	// The source saves a checkpoint on its way out; let it finish before
+	// the state dir is removed.
+	t.Cleanup(func() {
+		cancel()
+		wait(src, time.Second)
+	})
+
+	lines, err := collect(2, lineC, time.Second)
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if string(lines[0]) != "two" || string(lines[1]) != "three" {
+		t.Fatalf("unexpected output: '%s' '%s'", lines[0], lines[1])
+	}
+}
+
+func TestAttachFile_PauseResume(t *testing.T) {
+	tests := []struct {
+		name string
+		skip bool
+		// The first line read after resuming.
+		want string
+	}{
+		{"catch up", false, "written while paused"},
+		{"skip", true, "written after resuming"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ino, err := watch.NewInotify()
+			if err != nil {
+				t.Fatalf("Inotify: %v", err)
+			}
+			defer ino.Close()
+
+			tmp, spec, err := MakeSpec("pause")
+			if err != nil {
+				t.Fatalf("MakeSpec: %v", err)
+			}
+			defer os.Remove(spec.Path)
+
+			ctx, cancel := context.WithCancel(t.Context())
+			t.Cleanup(cancel)
+
+			src, err := Attach(ctx, spec, ino)
+			if err != nil {
+				t.Fatalf("Attach: %v", err)
+			}
+
+			lineC := make(chan []byte, 16)
+			go consume(ctx, src, lineC)
+			<-src.Ready
+
+			src.Control <- source.Control{Pause: true}
+
+			if _, err := write(tmp, []byte("written while paused\n")); err != nil {
+				t.Fatal(err)
+			}
+			if lines, err := collect(1, lineC, 200*time.Millisecond); err == nil {
+				t.Fatalf("read while paused: '%s'", lines[0])
+			}
+
+			src.Control <- source.Control{Skip: tc.skip}
+			// Only received once the resume is done with.
+			src.Control <- source.Control{}
+
+			if _, err := write(tmp, []byte("written after resuming\n")); err != nil {
+				t.Fatal(err)
+			}
+			lines, err := collect(1, lineC, time.Second)
+			if err != nil {
+				t.Fatalf("collect: %v", err)
+			}
+			if string(lines[0]) != tc.want {
+				t.Fatalf("unexpected line: '%s'", lines[0])
+			}
+
+			cancel()
+			if err := wait(src, time.Second); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
 }
@@ -0,0 +1,94 @@
+package file
+
+import (
+	"io"
+	"os"
+
+	"github.com/mdsn/gather/lib/source"
+)
+
+// Size of the blocks read when scanning backwards for newlines.
+const scanBlockSize = 4096
+
+// The offset at which to start reading fp: the checkpoint, if there is one
+// for this same file, or else the starting point of the spec.
+func resumeOffset(fp *os.File, spec *source.Spec, cps *checkpoints) (int64, error) {
+	cp, err := cps.load()
+	if err == nil && cp == nil {
+		return startOffset(fp, spec.Start)
+	}
+
+	// An unreadable checkpoint is as good as one for another file.
+	if err == nil {
+		if ok, err := cp.matches(fp); err == nil && ok {
+			return cp.Offset, nil
+		}
+	}
+
+	switch spec.Fallback {
+	case source.FallbackBeginning:
+		return 0, nil
+	case source.FallbackEnd:
+		return fileSize(fp)
+	default:
+		return startOffset(fp, spec.Start)
+	}
+}
+
+// The offset at which to start reading fp, as given by start.
+func startOffset(fp *os.File, start source.Start) (int64, error) {
+	switch start.Mode {
+	case source.StartBeginning:
+		return 0, nil
+	case source.StartOffset:
+		// An offset past EOF is brought back by the reader.
+		return start.Offset, nil
+	case source.StartLines:
+		return lastLines(fp, start.Lines)
+	default:
+		return fileSize(fp)
+	}
+}
+
+// The offset of the start of the last n lines of fp, found by scanning
+// backwards from EOF one block at a time. A final line without a newline
+// counts as a line. Returns 0 if the file has n lines or fewer.
+func lastLines(fp *os.File, n int) (int64, error) {
+	size, err := fileSize(fp)
+	if err != nil {
+		return -1, err
+	}
+	if n <= 0 {
+		return size, nil
+	}
+
+	buf := make([]byte, scanBlockSize)
+	pos := size
+	// A newline at EOF ends the last line rather than starting an empty one.
+	last := true
+
+	for pos > 0 {
+		sz := min(int64(len(buf)), pos)
+		pos -= sz
+		if _, err := fp.ReadAt(buf[:sz], pos); err != nil && err != io.EOF {
+			return -1, err
+		}
+
+		for i := sz - 1; i >= 0; i-- {
+			if buf[i] != '\n' {
+				last = false
+				continue
+			}
+			if last {
+				last = false
+				continue
+			}
+			n--
+			if n == 0 {
+				return pos + i + 1, nil
+			}
+		}
+	}
+
+	return 0, nil
+}
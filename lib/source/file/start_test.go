@@ -0,0 +1,87 @@
+package file
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mdsn/gather/lib/source"
+)
+
+func TestLastLines(t *testing.T) {
+	long := strings.Repeat("x", scanBlockSize*2+7)
+
+	tests := []struct {
+		name    string
+		content string
+		n       int
+		want    string
+	}{
+		{"empty", "", 3, ""},
+		{"fewer-lines", "one\ntwo\n", 3, "one\ntwo\n"},
+		{"exact", "one\ntwo\nthree\n", 3, "one\ntwo\nthree\n"},
+		{"last-two", "one\ntwo\nthree\n", 2, "two\nthree\n"},
+		{"no-final-newline", "one\ntwo\nthree", 1, "three"},
+		{"empty-lines", "one\n\n\n", 2, "\n\n"},
+		{"across-blocks", "one\n" + long + "\nlast\n", 2, long + "\nlast\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			path := t.TempDir() + "/lines"
+			if err := os.WriteFile(path, []byte(tc.content), 0600); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			fp, err := os.Open(path)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer fp.Close()
+
+			offset, err := lastLines(fp, tc.n)
+			if err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+			if got := tc.content[offset:]; got != tc.want {
+				t.Fatalf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestStartOffset(t *testing.T) {
+	content := "one\ntwo\nthree\n"
+	path := t.TempDir() + "/start"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	fp, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer fp.Close()
+
+	tests := []struct {
+		name  string
+		start source.Start
+		want  int64
+	}{
+		{"end", source.Start{Mode: source.StartEnd}, int64(len(content))},
+		{"beginning", source.Start{Mode: source.StartBeginning}, 0},
+		{"offset", source.Start{Mode: source.StartOffset, Offset: 4}, 4},
+		{"lines", source.Start{Mode: source.StartLines, Lines: 1}, 8},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			offset, err := startOffset(fp, tc.start)
+			if err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+			if offset != tc.want {
+				t.Fatal("wrong offset:", offset)
+			}
+		})
+	}
+}
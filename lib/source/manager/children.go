@@ -0,0 +1,60 @@
+package manager
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mdsn/gather/lib/source"
+)
+
+// Attaches the files found by a dir source as sources of their own. They are
+// removed along with it, and paused and resumed with it.
+type children struct {
+	m      *Manager
+	ctx    context.Context
+	parent string
+}
+
+func (c *children) Attach(spec *source.Spec) error {
+	m := c.m
+	err := m.attachEntry(c.ctx, spec, c.parent)
+
+	m.mu.Lock()
+	e, ok := m.sources[spec.Id]
+	// Left behind by an earlier dir source with the same id; take it over.
+	if errors.Is(err, ErrExists) && ok && e.parent == c.parent {
+		err = nil
+	}
+	var pause *source.Pause
+	if p, ok := m.sources[c.parent]; err == nil && ok && p.paused != nil && !p.paused.resumed {
+		opts := p.paused.opts
+		pause = &opts
+	}
+	m.mu.Unlock()
+
+	if pause != nil {
+		return m.Pause(spec.Id, *pause)
+	}
+	return err
+}
+
+func (c *children) Attached(id string) bool {
+	m := c.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.sources[id]
+	return ok && e.parent == c.parent
+}
+
+// Remove the source with the given id, unless it has been replaced by one
+// that is not a child.
+func (c *children) Detach(id string) error {
+	m := c.m
+	m.mu.Lock()
+	e, ok := m.sources[id]
+	m.mu.Unlock()
+	if !ok || e.parent != c.parent {
+		return nil
+	}
+	return m.Remove(id)
+}
@@ -0,0 +1,157 @@
+package manager
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mdsn/gather/lib/source"
+)
+
+// Bounds for a history buffer. The oldest records are evicted once either
+// bound is exceeded. Zero Lines disables the buffer; zero Bytes does not
+// bound it by size.
+type HistoryLimits struct {
+	Lines int
+	Bytes int
+}
+
+var (
+	DefaultSourceHistory = HistoryLimits{Lines: 1000, Bytes: 1 << 20}
+	DefaultGlobalHistory = HistoryLimits{Lines: 10000, Bytes: 8 << 20}
+)
+
+// Selects records from the history. Zero values select everything.
+type HistoryQuery struct {
+	// Glob patterns matched against source ids.
+	Patterns []string
+	// Only the last Lines records.
+	Lines int
+	// Only records captured at or after Since.
+	Since time.Time
+}
+
+// Recent records of the aggregated stream, kept per source and across all
+// of them.
+type History struct {
+	mu      sync.Mutex
+	limits  HistoryLimits
+	global  *ring
+	sources map[string]*ring
+}
+
+func NewHistory(perSource, global HistoryLimits) *History {
+	return &History{
+		limits:  perSource,
+		global:  newRing(global),
+		sources: make(map[string]*ring),
+	}
+}
+
+func (h *History) Record(out source.Output) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.global.push(out)
+
+	r, ok := h.sources[out.Id]
+	if !ok {
+		r = newRing(h.limits)
+		h.sources[out.Id] = r
+	}
+	r.push(out)
+}
+
+// Drop the records of a source from its own buffer. They are kept in the
+// global one until evicted.
+func (h *History) Forget(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.sources, id)
+}
+
+// The records selected by q, oldest first. A query for a single id is
+// answered from that source's buffer, which reaches further back than the
+// global one.
+func (h *History) Query(q HistoryQuery) []source.Output {
+	h.mu.Lock()
+	var records []source.Output
+	if r, ok := h.single(q.Patterns); ok {
+		records = r.records()
+	} else {
+		records = h.global.records()
+	}
+	h.mu.Unlock()
+
+	selected := records[:0]
+	for _, out := range records {
+		if out.CapturedAt.Before(q.Since) || !source.MatchId(q.Patterns, out.Id) {
+			continue
+		}
+		selected = append(selected, out)
+	}
+
+	if q.Lines > 0 && len(selected) > q.Lines {
+		selected = selected[len(selected)-q.Lines:]
+	}
+	return selected
+}
+
+// The buffer of the only source selected by patterns, if they name exactly
+// one and it has any history. Called with h.mu held.
+func (h *History) single(patterns []string) (*ring, bool) {
+	if len(patterns) != 1 {
+		return nil, false
+	}
+	r, ok := h.sources[patterns[0]]
+	return r, ok
+}
+
+// A circular buffer of records, bounded by count and total size.
+type ring struct {
+	buf      []source.Output
+	start    int
+	n        int
+	bytes    int
+	maxBytes int
+}
+
+func newRing(limits HistoryLimits) *ring {
+	return &ring{
+		buf:      make([]source.Output, max(limits.Lines, 0)),
+		maxBytes: limits.Bytes,
+	}
+}
+
+func (r *ring) push(out source.Output) {
+	if len(r.buf) == 0 {
+		return
+	}
+	if r.n == len(r.buf) {
+		r.pop()
+	}
+
+	r.buf[(r.start+r.n)%len(r.buf)] = out
+	r.n++
+	r.bytes += len(out.Bytes)
+
+	// Always keep the latest record, however large.
+	for r.maxBytes > 0 && r.bytes > r.maxBytes && r.n > 1 {
+		r.pop()
+	}
+}
+
+func (r *ring) pop() {
+	r.bytes -= len(r.buf[r.start].Bytes)
+	r.buf[r.start] = source.Output{}
+	r.start = (r.start + 1) % len(r.buf)
+	r.n--
+}
+
+// A copy of the records, oldest first.
+func (r *ring) records() []source.Output {
+	out := make([]source.Output, r.n)
+	for i := range r.n {
+		out[i] = r.buf[(r.start+i)%len(r.buf)]
+	}
+	return out
+}
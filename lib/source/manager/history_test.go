@@ -0,0 +1,112 @@
+package manager
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mdsn/gather/lib/source"
+)
+
+var epoch = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// Record lines for the given ids, one second apart.
+func record(h *History, ids ...string) {
+	for i, id := range ids {
+		h.Record(source.Output{
+			Id:         id,
+			CapturedAt: epoch.Add(time.Duration(i) * time.Second),
+			Bytes:      []byte(id + "-line"),
+		})
+	}
+}
+
+func ids(records []source.Output) []string {
+	var out []string
+	for _, r := range records {
+		out = append(out, r.Id)
+	}
+	return out
+}
+
+func TestRing_EvictsByLines(t *testing.T) {
+	r := newRing(HistoryLimits{Lines: 3})
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		r.push(source.Output{Id: id})
+	}
+
+	if got := ids(r.records()); !slices.Equal(got, []string{"c", "d", "e"}) {
+		t.Fatalf("unexpected records: %v", got)
+	}
+}
+
+func TestRing_EvictsByBytes(t *testing.T) {
+	r := newRing(HistoryLimits{Lines: 10, Bytes: 10})
+	for _, id := range []string{"a", "b", "c"} {
+		r.push(source.Output{Id: id, Bytes: []byte("1234")})
+	}
+
+	if got := ids(r.records()); !slices.Equal(got, []string{"b", "c"}) {
+		t.Fatalf("unexpected records: %v", got)
+	}
+	if r.bytes != 8 {
+		t.Fatal("wrong byte count:", r.bytes)
+	}
+
+	// A record over the limit on its own is still kept.
+	r.push(source.Output{Id: "d", Bytes: make([]byte, 20)})
+	if got := ids(r.records()); !slices.Equal(got, []string{"d"}) {
+		t.Fatalf("unexpected records: %v", got)
+	}
+}
+
+func TestRing_Disabled(t *testing.T) {
+	r := newRing(HistoryLimits{})
+	r.push(source.Output{Id: "a"})
+
+	if len(r.records()) != 0 {
+		t.Fatal("disabled ring kept records")
+	}
+}
+
+func TestHistory_Query(t *testing.T) {
+	h := NewHistory(HistoryLimits{Lines: 10}, HistoryLimits{Lines: 4})
+	record(h, "a", "b", "a", "b", "a", "b")
+
+	tests := []struct {
+		name string
+		q    HistoryQuery
+		want []string
+	}{
+		{"global", HistoryQuery{}, []string{"a", "b", "a", "b"}},
+		{"single-source", HistoryQuery{Patterns: []string{"a"}}, []string{"a", "a", "a"}},
+		{"lines", HistoryQuery{Patterns: []string{"a"}, Lines: 2}, []string{"a", "a"}},
+		{"glob", HistoryQuery{Patterns: []string{"[b]"}}, []string{"b", "b"}},
+		{"since", HistoryQuery{Since: epoch.Add(4 * time.Second)}, []string{"a", "b"}},
+		{"unknown", HistoryQuery{Patterns: []string{"c"}}, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			if got := ids(h.Query(tc.q)); !slices.Equal(got, tc.want) {
+				t.Fatalf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHistory_Forget(t *testing.T) {
+	h := NewHistory(HistoryLimits{Lines: 10}, HistoryLimits{Lines: 10})
+	record(h, "a", "b")
+	h.Forget("a")
+
+	if _, ok := h.sources["a"]; ok {
+		t.Fatal("source history kept after Forget")
+	}
+
+	// Still in the global history.
+	if got := ids(h.Query(HistoryQuery{Patterns: []string{"a"}})); !slices.Equal(got, []string{"a"}) {
+		t.Fatalf("unexpected records: %v", got)
+	}
+}
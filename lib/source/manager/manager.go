@@ -4,106 +4,519 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/mdsn/gather/lib/source"
+	"github.com/mdsn/gather/lib/source/dir"
 	"github.com/mdsn/gather/lib/source/file"
 	"github.com/mdsn/gather/lib/source/proc"
 	"github.com/mdsn/gather/lib/watch"
 )
 
+var (
+	ErrExists     = errors.New("source already exists")
+	ErrNotFound   = errors.New("source not found")
+	ErrNotRunning = errors.New("source is not running")
+)
+
+// A source attached through the Manager, along with the Spec it was created
+// from. A proc source with a restart policy gets a new src every time it is
+// restarted.
+type entry struct {
+	src        *source.Source
+	spec       *source.Spec
+	attachedAt time.Time
+	// Cancels the source and any pending restart.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Nil unless the source has a restart policy.
+	restarter  *proc.Restarter
+	restarting bool
+	restarts   int
+	// Set while the source is paused, or resumed with held output that
+	// fanIn has yet to forward.
+	paused *paused
+	// Wakes up fanIn to forward held output after a resume.
+	wake chan struct{}
+	// The id of the dir source that attached this one, if any.
+	parent string
+}
+
+// The state of the entry, accounting for a pending restart. Called with m.mu
+// held.
+func (e *entry) state() source.State {
+	if e.restarting {
+		return source.StateRestarting
+	}
+	st := e.src.State()
+	if st == source.StateRunning && e.paused != nil && !e.paused.resumed {
+		return source.StatePaused
+	}
+	return st
+}
+
+// A snapshot of the entry. Called with m.mu held.
+func (e *entry) status() Status {
+	st := Status{
+		Id:         e.src.Id,
+		Kind:       e.src.Kind,
+		Path:       e.spec.Path,
+		Glob:       e.spec.Glob,
+		Args:       slices.Clone(e.spec.Args),
+		AttachedAt: e.attachedAt,
+		State:      e.state(),
+		Restarts:   e.restarts,
+	}
+	// Exit is only safe to read once Done is closed.
+	if st.State == source.StateExited || st.State == source.StateRestarting {
+		st.Exit = e.src.Exit
+	}
+	return st
+}
+
+// A snapshot of an attached source, as reported by List().
+type Status struct {
+	Id         string
+	Kind       source.SourceKind
+	Path       string
+	Args       []string
+	AttachedAt time.Time
+	State      source.State
+	// Number of times a proc source was restarted.
+	Restarts int
+	// How the process ended, for exited proc sources.
+	Exit *source.Exit
+	// The files in Path a dir source attaches.
+	Glob string
+}
+
 type Manager struct {
 	inotify *watch.Inotify
-	// Synchronizes access to sources
+	// Watches files where inotify does not work.
+	poll *watch.Poll
+	// Synchronizes access to sources and attaching
 	mu      sync.Mutex
-	sources map[string]*source.Source
+	sources map[string]*entry
+	// Ids of the sources being attached, taken until they are in sources.
+	attaching map[string]bool
+	// Recent records sent on Events
+	history *History
 	// Output from sources is fanned into this channel
 	Events chan source.Output
 }
 
-func NewManager() *Manager {
+// Create a Manager keeping the given amount of history for each source and
+// for all of them together.
+func NewManager(perSource, global HistoryLimits) *Manager {
 	ino, err := watch.NewInotify()
 	if err != nil {
 		return nil // XXX ??
 	}
 	return &Manager{
-		inotify: ino,
-		sources: make(map[string]*source.Source),
-		Events:  make(chan source.Output),
+		inotify:   ino,
+		poll:      watch.NewPoll(),
+		sources:   make(map[string]*entry),
+		attaching: make(map[string]bool),
+		history:   NewHistory(perSource, global),
+		Events:    make(chan source.Output),
 	}
 }
 
+// Stop every source and wait for them to be done, then release the inotify
+// instance and the pollers.
 func (m *Manager) Close() error {
-	return m.inotify.Close()
+	m.mu.Lock()
+	entries := make([]*entry, 0, len(m.sources))
+	for _, e := range m.sources {
+		entries = append(entries, e)
+	}
+	m.mu.Unlock()
+
+	for _, e := range entries {
+		e.cancel()
+	}
+	for _, e := range entries {
+		m.mu.Lock()
+		src := e.src
+		m.mu.Unlock()
+		<-src.Done
+	}
+
+	return errors.Join(m.poll.Close(), m.inotify.Close())
 }
 
 func (m *Manager) Attach(ctx context.Context, spec *source.Spec) error {
-	var src *source.Source
-	var err error
+	spec, err := fileGlob(spec)
+	if err != nil {
+		return err
+	}
+	return m.attachEntry(ctx, spec, "")
+}
+
+// Attach a source on behalf of the dir source with the given id, or of
+// nobody if it is empty.
+func (m *Manager) attachEntry(ctx context.Context, spec *source.Spec, parent string) error {
+	// An exited source keeps its id until it is removed or replaced. The id
+	// is taken while the source is attached, so that nobody else attaches
+	// one under it meanwhile.
+	m.mu.Lock()
+	e, ok := m.sources[spec.Id]
+	if ok && e.state() != source.StateExited || m.attaching[spec.Id] {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: '%s'", ErrExists, spec.Id)
+	}
+	m.attaching[spec.Id] = true
+	m.mu.Unlock()
+
+	ectx, cancel := context.WithCancel(ctx)
+	src, err := m.attach(ectx, spec)
+	if err != nil {
+		cancel()
+		m.mu.Lock()
+		delete(m.attaching, spec.Id)
+		m.mu.Unlock()
+		return err
+	}
+
+	e = &entry{
+		src:        src,
+		spec:       spec,
+		attachedAt: time.Now(),
+		ctx:        ectx,
+		cancel:     cancel,
+		wake:       make(chan struct{}, 1),
+		parent:     parent,
+	}
+	if spec.Kind == source.KindProc && spec.Restart.Mode != source.RestartNever {
+		e.restarter = proc.NewRestarter(spec.Restart)
+	}
+
+	m.mu.Lock()
+	prev := m.sources[src.Id]
+	m.sources[src.Id] = e
+	delete(m.attaching, src.Id)
+	m.mu.Unlock()
+
+	// Make sure a replaced entry does not come back on its own.
+	if prev != nil {
+		prev.cancel()
+	}
+
+	go m.run(ctx, e)
+
+	return nil
+}
 
+// Start the source described by spec.
+func (m *Manager) attach(ctx context.Context, spec *source.Spec) (*source.Source, error) {
 	switch spec.Kind {
 	case source.KindProc:
-		src, err = proc.Attach(ctx, spec)
+		return proc.Attach(ctx, spec)
+
+	case source.KindDir:
+		w, err := m.watcher(spec)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		return dir.Attach(ctx, spec, w, &children{m: m, ctx: ctx, parent: spec.Id})
 
 	case source.KindFile:
-		handle, err := m.inotify.Add(spec.Path)
+		w, err := m.watcher(spec)
 		if err != nil {
-			return fmt.Errorf("inotify: %v", err)
+			return nil, err
 		}
 
-		src, err = file.Attach(ctx, spec, handle)
-		if err != nil {
-			err = errors.Join(err, m.inotify.Rm(handle))
-			return fmt.Errorf("attach: %v", err)
+		attach := file.Attach
+		if spec.Follow == source.FollowName {
+			attach = file.AttachName
 		}
+		return attach(ctx, spec, w)
+
 	default:
-		return errors.New("unknown SourceKind")
+		return nil, errors.New("unknown SourceKind")
 	}
+}
 
-	m.mu.Lock()
-	m.sources[src.Id] = src
-	m.mu.Unlock()
+// A file source with a glob in its file name, and no file by that very name,
+// stands for a dir source for the files matching it. Only the last element of
+// the path may be a glob. Returns spec itself otherwise.
+func fileGlob(spec *source.Spec) (*source.Spec, error) {
+	if spec.Kind != source.KindFile || !strings.ContainsAny(spec.Path, `*?[`) {
+		return spec, nil
+	}
+	if _, err := os.Lstat(spec.Path); err == nil {
+		return spec, nil
+	}
+
+	dir, glob := filepath.Split(spec.Path)
+	if strings.ContainsAny(dir, `*?[`) {
+		return nil, errors.New(fmt.Sprintf("only the file name may be a glob, got '%s'", spec.Path))
+	}
+	if _, err := path.Match(glob, ""); err != nil {
+		return nil, errors.New(fmt.Sprintf("bad pattern '%s'", glob))
+	}
+	if spec.Follow == source.FollowName {
+		return nil, errors.New("a glob cannot be followed by name")
+	}
+
+	g := *spec
+	g.Kind = source.KindDir
+	g.Path = filepath.Clean(dir)
+	g.Glob = glob
+	return &g, nil
+}
+
+// The watcher for the file or dir source described by spec. With WatchAuto,
+// paths on filesystems inotify does not work on are polled.
+func (m *Manager) watcher(spec *source.Spec) (watch.Watcher, error) {
+	switch spec.Watch {
+	case source.WatchInotify:
+		return m.inotify, nil
+	case source.WatchPoll:
+		return m.poll, nil
+	}
 
-	// Fan into the manager's Events channel.
-	go func() {
-		// Remove source, ignoring error
-		defer m.Remove(src.Id)
+	poll, err := watch.NeedsPoll(spec.Path)
+	if err != nil {
+		return nil, err
+	}
+	if poll {
+		return m.poll, nil
+	}
+	return m.inotify, nil
+}
+
+// Fan the output of an entry into the manager's Events channel, restarting
+// it as its policy dictates. The entry stays in the map after it exits, so
+// its status can be inspected until it is removed.
+func (m *Manager) run(ctx context.Context, e *entry) {
+	src := e.src
+	for {
+		if !m.fanIn(ctx, e, src) {
+			return
+		}
+		m.exited(ctx, src)
+
+		src = m.restart(ctx, e, src)
+		if src == nil {
+			return
+		}
+	}
+}
 
-		for {
-			select {
+// Forward output from src until its Out channel is closed, holding it back
+// while the entry is paused. Returns false if ctx was cancelled first.
+func (m *Manager) fanIn(ctx context.Context, e *entry, src *source.Source) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-e.wake:
+			if !m.release(ctx, e) {
+				return false
+			}
+		case out, ok := <-src.Out:
+			if !ok {
+				return true
+			}
+			// Whatever was held comes out first.
+			if !m.release(ctx, e) {
+				return false
+			}
+			if m.hold(e, out) {
+				continue
+			}
+			m.history.Record(out)
+			select { // Prevent blocking on the send
+			case m.Events <- out:
 			case <-ctx.Done():
-				return
-			case out, ok := <-src.Out:
-				if !ok {
-					return
-				}
-				select { // Prevent blocking on the send
-				case m.Events <- out:
-				case <-ctx.Done():
-					return
-				}
+				return false
 			}
 		}
-	}()
+	}
+}
 
-	return nil
+// Report the exit of a process as a lifecycle event, once its source is done.
+func (m *Manager) exited(ctx context.Context, src *source.Source) {
+	<-src.Done
+	if src.Exit == nil {
+		return
+	}
+
+	m.send(ctx, source.Output{
+		Id:         src.Id,
+		Kind:       src.Kind,
+		CapturedAt: time.Now(),
+		Stream:     source.StreamEvent,
+		Bytes:      []byte("exited " + src.Exit.String()),
+		Exit:       src.Exit,
+	})
+}
+
+// Wait out the backoff and start the entry again, if its restart policy
+// allows. Returns the new source, or nil if the entry stays down.
+func (m *Manager) restart(ctx context.Context, e *entry, prev *source.Source) *source.Source {
+	// Removed, or gather is shutting down.
+	if e.restarter == nil || e.ctx.Err() != nil {
+		return nil
+	}
+
+	delay, ok := e.restarter.Next(prev.Exit, time.Now())
+	if !ok {
+		// Short of a clean exit under on-failure, the restart limit was
+		// reached.
+		if e.spec.Restart.Mode != source.RestartOnFailure || prev.Exit.Status != 0 {
+			policy := e.restarter.Policy()
+			m.event(ctx, prev, fmt.Sprintf("not restarting: %d restarts within %s",
+				policy.MaxRestarts, policy.Window))
+		}
+		return nil
+	}
+
+	m.mu.Lock()
+	e.restarting = true
+	m.mu.Unlock()
+
+	m.event(ctx, prev, fmt.Sprintf("restarting in %s", delay))
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-e.ctx.Done():
+		// Removed while waiting.
+		return nil
+	}
+
+	src, err := proc.Attach(e.ctx, e.spec)
+
+	m.mu.Lock()
+	e.restarting = false
+	if err == nil {
+		e.src = src
+		e.restarts++
+	}
+	m.mu.Unlock()
+
+	if err != nil {
+		m.event(ctx, prev, fmt.Sprintf("restart failed: %v", err))
+		return nil
+	}
+
+	return src
+}
+
+// Send a lifecycle event about src with the given message.
+func (m *Manager) event(ctx context.Context, src *source.Source, msg string) {
+	m.send(ctx, source.Output{
+		Id:         src.Id,
+		Kind:       src.Kind,
+		CapturedAt: time.Now(),
+		Stream:     source.StreamEvent,
+		Bytes:      []byte(msg),
+	})
+}
+
+func (m *Manager) send(ctx context.Context, out source.Output) {
+	m.history.Record(out)
+	select {
+	case m.Events <- out:
+	case <-ctx.Done():
+	}
 }
 
 func (m *Manager) Remove(id string) error {
 	m.mu.Lock()
-	src, ok := m.sources[id]
+	e, ok := m.sources[id]
 	if !ok {
 		m.mu.Unlock()
-		return errors.New(fmt.Sprintf("source '%s' not found", id))
+		return fmt.Errorf("%w: '%s'", ErrNotFound, id)
 	}
 
 	delete(m.sources, id)
+	src := e.src
 	m.mu.Unlock()
 
-	src.Cancel()
+	e.cancel()
 	<-src.Done
+
+	m.history.Forget(id)
+
+	// The files of a dir source go with it.
+	for _, child := range m.children(id) {
+		_ = m.Remove(child)
+	}
 	return nil
 }
+
+// The ids of the sources attached by the dir source with the given id.
+func (m *Manager) children(parent string) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ids []string
+	for id, e := range m.sources {
+		if e.parent == parent {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// The status of the source with the given id, if it is attached.
+func (m *Manager) Status(id string) (Status, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.sources[id]
+	if !ok {
+		return Status{}, false
+	}
+	return e.status(), true
+}
+
+// List the status of every attached source, sorted by id.
+func (m *Manager) List() []Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]Status, 0, len(m.sources))
+	for _, e := range m.sources {
+		list = append(list, e.status())
+	}
+
+	slices.SortFunc(list, func(a, b Status) int {
+		return strings.Compare(a.Id, b.Id)
+	})
+	return list
+}
+
+// Counters kept by the manager, for `stats`.
+type Stats struct {
+	Sources int
+	// Times the inotify event queue overflowed, losing events. Every watch
+	// rescans when that happens.
+	InotifyOverflows uint64
+}
+
+func (m *Manager) Stats() Stats {
+	m.mu.Lock()
+	n := len(m.sources)
+	m.mu.Unlock()
+
+	return Stats{
+		Sources:          n,
+		InotifyOverflows: m.inotify.Overflows(),
+	}
+}
+
+// Recent records sent on Events, oldest first.
+func (m *Manager) History(q HistoryQuery) []source.Output {
+	return m.history.Query(q)
+}
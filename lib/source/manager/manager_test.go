@@ -0,0 +1,167 @@
+package manager
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mdsn/gather/lib/source"
+)
+
+func TestManager_AttachSameIdConcurrently(t *testing.T) {
+	m := NewManager(DefaultSourceHistory, DefaultGlobalHistory)
+	defer m.Close()
+
+	// Only one of them gets the id; the others are not attached and then
+	// replaced.
+	var wg sync.WaitGroup
+	errC := make(chan error, 8)
+	for range 8 {
+		wg.Go(func() {
+			spec := &source.Spec{Id: "dup", Kind: source.KindProc, Path: "sleep", Args: []string{"10"}}
+			errC <- m.Attach(t.Context(), spec)
+		})
+	}
+	wg.Wait()
+	close(errC)
+
+	attached := 0
+	for err := range errC {
+		switch {
+		case err == nil:
+			attached++
+		case !errors.Is(err, ErrExists):
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if attached != 1 {
+		t.Fatalf("attached %d times", attached)
+	}
+}
+
+func TestManager_RemoveDoesNotRestart(t *testing.T) {
+	for _, mode := range []source.RestartMode{source.RestartAlways, source.RestartOnFailure} {
+		t.Run(mode.String(), func(t *testing.T) {
+			t.Parallel()
+			m := NewManager(DefaultSourceHistory, DefaultGlobalHistory)
+			defer m.Close()
+			recC := receive(t.Context(), m)
+
+			spec := &source.Spec{
+				Id:   "worker",
+				Kind: source.KindProc,
+				Path: "sleep",
+				Args: []string{"10"},
+				Restart: source.Restart{
+					Mode:       mode,
+					Backoff:    source.DefaultRestartBackoff,
+					MaxBackoff: source.DefaultRestartMaxBackoff,
+					Window:     source.DefaultRestartWindow,
+				},
+			}
+			if err := m.Attach(t.Context(), spec); err != nil {
+				t.Fatalf("Attach: %v", err)
+			}
+			if err := m.Remove("worker"); err != nil {
+				t.Fatalf("Remove: %v", err)
+			}
+
+			// The exit may still be reported, but nothing about restarting.
+			got, _ := events(recC, 3, 300*time.Millisecond)
+			for _, ev := range got {
+				if strings.Contains(ev, "restart") {
+					t.Fatalf("unexpected events: %q", got)
+				}
+			}
+		})
+	}
+}
+
+func TestManager_RemoveFileStopsFanIn(t *testing.T) {
+	m := NewManager(DefaultSourceHistory, DefaultGlobalHistory)
+	defer m.Close()
+
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	spec := &source.Spec{Id: "app", Kind: source.KindFile, Path: path}
+
+	before := runtime.NumGoroutine()
+	for range 50 {
+		if err := m.Attach(t.Context(), spec); err != nil {
+			t.Fatalf("Attach: %v", err)
+		}
+		if err := m.Remove("app"); err != nil {
+			t.Fatalf("Remove: %v", err)
+		}
+	}
+
+	// Nothing of the removed sources stays behind.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines went from %d to %d", before, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFileGlob(t *testing.T) {
+	dir := t.TempDir()
+	// A file that merely looks like a glob.
+	literal := filepath.Join(dir, "worker[1]*.log")
+	if err := os.WriteFile(literal, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		spec source.Spec
+		// What it is attached as.
+		kind source.SourceKind
+		path string
+		glob string
+	}{
+		{"plain", source.Spec{Kind: source.KindFile, Path: "/var/log/syslog"}, source.KindFile, "/var/log/syslog", ""},
+		{"glob", source.Spec{Kind: source.KindFile, Path: "/var/log/app/*.log"}, source.KindDir, "/var/log/app", "*.log"},
+		{"relative", source.Spec{Kind: source.KindFile, Path: "worker-[0-9].log"}, source.KindDir, ".", "worker-[0-9].log"},
+		{"exists", source.Spec{Kind: source.KindFile, Path: literal}, source.KindFile, literal, ""},
+		{"dir", source.Spec{Kind: source.KindDir, Path: "/var/log/app", Glob: "*.log"}, source.KindDir, "/var/log/app", "*.log"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := fileGlob(&tc.spec)
+			if err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+			if got.Kind != tc.kind || got.Path != tc.path || got.Glob != tc.glob {
+				t.Fatalf("got %s %s %q", got.Kind, got.Path, got.Glob)
+			}
+		})
+	}
+}
+
+func TestFileGlob_Errors(t *testing.T) {
+	tests := []source.Spec{
+		{Kind: source.KindFile, Path: "/var/log/*/app.log"},
+		{Kind: source.KindFile, Path: "/var/log/app/[.log"},
+		{Kind: source.KindFile, Path: "/var/log/app/*.log", Follow: source.FollowName},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Path, func(t *testing.T) {
+			t.Parallel()
+			if _, err := fileGlob(&tc); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mdsn/gather/lib/source"
+)
+
+// Output held back from a paused entry.
+type paused struct {
+	opts source.Pause
+	held *ring
+	// Lines discarded or evicted from held.
+	dropped int
+	// Resumed, but fanIn has yet to forward what was held.
+	resumed bool
+	skip    bool
+}
+
+// Pause the source with the given id. A file source stops reading and keeps
+// its offset. The process of a proc source keeps running; its output is
+// buffered or discarded as opts says. Pausing a paused source only changes
+// its options; if the buffer shrinks, the oldest lines held are dropped.
+func (m *Manager) Pause(id string, opts source.Pause) error {
+	if opts.Buffer <= 0 {
+		opts.Buffer = source.DefaultPauseBuffer
+	}
+
+	m.mu.Lock()
+	e, ok := m.sources[id]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: '%s'", ErrNotFound, id)
+	}
+	if e.state() == source.StateExited {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: '%s'", ErrNotRunning, id)
+	}
+	// Output still held from before a resume stays in the buffer.
+	if e.paused == nil {
+		e.paused = &paused{held: newRing(HistoryLimits{Lines: opts.Buffer})}
+	} else if len(e.paused.held.buf) != opts.Buffer {
+		e.paused.resize(opts.Buffer)
+	}
+	e.paused.opts = opts
+	e.paused.resumed = false
+	e.paused.skip = false
+	src := e.src
+	m.mu.Unlock()
+
+	// Lines sent by a file source before it gets this are held as well.
+	if src.Control != nil {
+		select {
+		case src.Control <- source.Control{Pause: true}:
+		case <-src.Done:
+			return fmt.Errorf("%w: '%s'", ErrNotRunning, id)
+		}
+	}
+
+	m.event(e.ctx, src, "paused")
+
+	// The files of a dir source are paused with it.
+	if src.Kind == source.KindDir {
+		for _, child := range m.children(id) {
+			_ = m.Pause(child, opts)
+		}
+	}
+	return nil
+}
+
+// Hold up to n lines, keeping the latest of those already held.
+func (p *paused) resize(n int) {
+	held := newRing(HistoryLimits{Lines: n})
+	for _, out := range p.held.records() {
+		held.push(out)
+	}
+	p.dropped += p.held.n - held.n
+	p.held = held
+}
+
+// Resume the source with the given id, forwarding whatever it held back
+// unless opts says to skip it. Resuming a source that is not paused does
+// nothing.
+func (m *Manager) Resume(id string, opts source.Resume) error {
+	if err := m.resume(id, opts); err != nil {
+		return err
+	}
+	for _, child := range m.children(id) {
+		_ = m.resume(child, opts)
+	}
+	return nil
+}
+
+func (m *Manager) resume(id string, opts source.Resume) error {
+	m.mu.Lock()
+	e, ok := m.sources[id]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: '%s'", ErrNotFound, id)
+	}
+	if e.paused == nil || e.paused.resumed {
+		m.mu.Unlock()
+		return nil
+	}
+	e.paused.resumed = true
+	e.paused.skip = opts.Skip
+	src := e.src
+	exited := e.state() == source.StateExited
+	m.mu.Unlock()
+
+	// Nothing is fanning in an exited source; forward the rest from here.
+	if exited {
+		m.release(e.ctx, e)
+		return nil
+	}
+
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+
+	if src.Control != nil {
+		select {
+		case src.Control <- source.Control{Skip: opts.Skip}:
+		case <-src.Done:
+		}
+	}
+	return nil
+}
+
+// Hold out back if e is paused. Returns false if it is to be forwarded.
+func (m *Manager) hold(e *entry, out source.Output) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := e.paused
+	if p == nil || p.resumed {
+		return false
+	}
+	if p.opts.Discard || len(p.held.buf) == 0 {
+		p.dropped++
+		return true
+	}
+	if p.held.n == len(p.held.buf) {
+		p.dropped++
+	}
+	p.held.push(out)
+	return true
+}
+
+// Forward what a resumed entry held back, after an event saying how much
+// was dropped. Returns false if ctx was cancelled first.
+func (m *Manager) release(ctx context.Context, e *entry) bool {
+	m.mu.Lock()
+	p := e.paused
+	if p == nil || !p.resumed {
+		m.mu.Unlock()
+		return true
+	}
+	e.paused = nil
+	src := e.src
+	m.mu.Unlock()
+
+	var held []source.Output
+	dropped := p.dropped
+	if p.skip {
+		dropped += p.held.n
+	} else {
+		held = p.held.records()
+	}
+
+	msg := "resumed"
+	if dropped > 0 {
+		msg = fmt.Sprintf("resumed, %d lines dropped while paused", dropped)
+	}
+	m.event(ctx, src, msg)
+
+	for _, out := range held {
+		m.send(ctx, out)
+	}
+	return ctx.Err() == nil
+}
@@ -0,0 +1,135 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mdsn/gather/lib/source"
+)
+
+// Read Events into a channel, as strings, until ctx is done.
+func receive(ctx context.Context, m *Manager) chan string {
+	recC := make(chan string, 16)
+	go func() {
+		for {
+			select {
+			case out := <-m.Events:
+				select {
+				case recC <- string(out.Bytes):
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return recC
+}
+
+// Collect n records from recC.
+func events(recC chan string, n int, deadline time.Duration) ([]string, error) {
+	timeout := time.NewTimer(deadline)
+	defer timeout.Stop()
+
+	var got []string
+	for len(got) < n {
+		select {
+		case out := <-recC:
+			got = append(got, out)
+		case <-timeout.C:
+			return got, errors.New(fmt.Sprintf("timeout; wanted %d records, got %d", n, len(got)))
+		}
+	}
+	return got, nil
+}
+
+func TestManager_PauseProc(t *testing.T) {
+	tests := []struct {
+		name  string
+		pause source.Pause
+		// Paused again with these options before resuming, if set.
+		repause *source.Pause
+		want    []string
+	}{
+		{"buffer", source.Pause{Buffer: 2}, nil, []string{"resumed, 1 lines dropped while paused", "b", "c"}},
+		{"discard", source.Pause{Discard: true}, nil, []string{"resumed, 3 lines dropped while paused"}},
+		{"shrink", source.Pause{Buffer: 3}, &source.Pause{Buffer: 1}, []string{"resumed, 2 lines dropped while paused", "c"}},
+		{"grow", source.Pause{Buffer: 2}, &source.Pause{Buffer: 5}, []string{"resumed, 1 lines dropped while paused", "b", "c"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			m := NewManager(DefaultSourceHistory, DefaultGlobalHistory)
+			defer m.Close()
+			recC := receive(t.Context(), m)
+
+			spec := &source.Spec{
+				Id:   "worker",
+				Kind: source.KindProc,
+				Path: "sh",
+				Args: []string{"-c", "sleep 0.2; echo a; echo b; echo c; sleep 10"},
+			}
+			if err := m.Attach(t.Context(), spec); err != nil {
+				t.Fatalf("Attach: %v", err)
+			}
+
+			if err := m.Pause("worker", tc.pause); err != nil {
+				t.Fatalf("Pause: %v", err)
+			}
+			got, err := events(recC, 1, time.Second)
+			if err != nil || got[0] != "paused" {
+				t.Fatalf("unexpected events: %q, %v", got, err)
+			}
+			if st, _ := m.Status("worker"); st.State != source.StatePaused {
+				t.Fatalf("unexpected state: %s", st.State)
+			}
+
+			// The process keeps writing while paused.
+			if got, err := events(recC, 1, 700*time.Millisecond); err == nil {
+				t.Fatalf("output while paused: %q", got)
+			}
+
+			if tc.repause != nil {
+				if err := m.Pause("worker", *tc.repause); err != nil {
+					t.Fatalf("Pause: %v", err)
+				}
+				got, err := events(recC, 1, time.Second)
+				if err != nil || got[0] != "paused" {
+					t.Fatalf("unexpected events: %q, %v", got, err)
+				}
+			}
+
+			if err := m.Resume("worker", source.Resume{}); err != nil {
+				t.Fatalf("Resume: %v", err)
+			}
+			got, err = events(recC, len(tc.want), time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Fatalf("unexpected events: %q", got)
+			}
+			if st, _ := m.Status("worker"); st.State != source.StateRunning {
+				t.Fatalf("unexpected state: %s", st.State)
+			}
+		})
+	}
+}
+
+func TestManager_PauseNotFound(t *testing.T) {
+	m := NewManager(DefaultSourceHistory, DefaultGlobalHistory)
+	defer m.Close()
+
+	if err := m.Pause("nope", source.Pause{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := m.Resume("nope", source.Resume{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package proc
+
+import (
+	"errors"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Every proc source runs in its own process group, led by the spawned child,
+// so that stopping it reaches whatever the child spawned as well, unless a
+// descendant moved itself to a different group or session.
+
+// Whether gather is a child subreaper; see SetSubreaper.
+var subreaper atomic.Bool
+
+// Make gather the subreaper of its descendants with PR_SET_CHILD_SUBREAPER.
+// Grandchildren orphaned by a proc source are then reparented to gather
+// instead of init, and reaped when their group is done.
+func SetSubreaper() error {
+	if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+		return err
+	}
+	subreaper.Store(true)
+	return nil
+}
+
+// Send sig to the process group pgid. Anything left in the group after
+// timeout gets SIGKILL, unless the returned timer is stopped first. Once the
+// group is gone its pgid may be reused, so the timer must be stopped then.
+// There is no timer if sig is SIGKILL already.
+func stopGroup(pgid int, sig syscall.Signal, timeout time.Duration) (*time.Timer, error) {
+	err := unix.Kill(-pgid, sig)
+	if sig == unix.SIGKILL {
+		return nil, err
+	}
+	kill := time.AfterFunc(timeout, func() {
+		_ = unix.Kill(-pgid, unix.SIGKILL)
+	})
+	return kill, err
+}
+
+// Stop what is left of the group pgid after its leader exited on its own,
+// like stopGroup. Nothing waits on the group, so it is looked at until it is
+// gone and the SIGKILL can be called off.
+func stopOrphans(pgid int, sig syscall.Signal, timeout time.Duration) {
+	kill, _ := stopGroup(pgid, sig, timeout)
+	if kill == nil {
+		return
+	}
+	deadline := time.Now().Add(timeout)
+	for groupAlive(pgid) {
+		if time.Now().After(deadline) {
+			return
+		}
+		time.Sleep(orphanPollInterval)
+	}
+	kill.Stop()
+}
+
+// How often stopOrphans looks for the group to be gone.
+const orphanPollInterval = 50 * time.Millisecond
+
+// Whether anything is left in the process group pgid.
+func groupAlive(pgid int) bool {
+	return !errors.Is(unix.Kill(-pgid, 0), unix.ESRCH)
+}
+
+// Reap members of the group pgid that were reparented to gather, until none
+// are left. Must only be called after the group leader was waited on, so it
+// does not steal its status from os/exec.
+func reapGroup(pgid int) {
+	for {
+		_, err := unix.Wait4(-pgid, nil, 0, nil)
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil {
+			// ECHILD: no children left in the group.
+			return
+		}
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/mdsn/gather/lib/source"
@@ -20,27 +22,81 @@ func Attach(ctx context.Context, spec *source.Spec) (*source.Source, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	cmd := exec.CommandContext(ctx, spec.Path, spec.Args...)
 
+	stopSignal := spec.StopSignal
+	if stopSignal == 0 {
+		stopSignal = source.DefaultStopSignal
+	}
+	stopTimeout := spec.StopTimeout
+	if stopTimeout <= 0 {
+		stopTimeout = source.DefaultStopTimeout
+	}
+
+	// Lead a new process group, and stop the whole group when the context
+	// is done. If the child is still around after the stop timeout, os/exec
+	// kills it; stopGroup takes care of the rest of the group.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// The pending SIGKILL to the group. Set by Cancel, which is done with
+	// by the time Wait returns.
+	var kill *time.Timer
+	cmd.Cancel = func() error {
+		var err error
+		kill, err = stopGroup(cmd.Process.Pid, stopSignal, stopTimeout)
+		return err
+	}
+	cmd.WaitDelay = stopTimeout
+
 	// Create pipes
 	rp, wp, err := os.Pipe()
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
-	// TODO 01-design says both stdin and stderr are streamed and aggregated.
 	// Assign write end to the child
 	cmd.Stdout = wp
+	pipes := []pipe{{rp, source.StreamStdout}}
+	writers := []*os.File{wp}
+
+	// Stderr either shares the stdout pipe or gets its own.
+	switch spec.Stderr {
+	case source.StderrTag:
+		erp, ewp, err := os.Pipe()
+		if err != nil {
+			cancel()
+			rp.Close()
+			wp.Close()
+			return nil, err
+		}
+		cmd.Stderr = ewp
+		pipes = append(pipes, pipe{erp, source.StreamStderr})
+		writers = append(writers, ewp)
+	default:
+		cmd.Stderr = wp
+	}
+
+	closePipes := func() {
+		for _, p := range pipes {
+			p.r.Close()
+		}
+		for _, w := range writers {
+			w.Close()
+		}
+	}
 
 	// Fork/exec
 	if err := cmd.Start(); err != nil {
-		rp.Close()
-		wp.Close()
+		cancel()
+		closePipes()
 		return nil, err
 	}
 
-	// Close parent copy of the write pipe
-	if err := wp.Close(); err != nil {
-		rp.Close()
-		return nil, err
+	// Close parent copy of the write pipes
+	for _, w := range writers {
+		if err := w.Close(); err != nil {
+			cancel()
+			closePipes()
+			return nil, err
+		}
 	}
 
 	// Create *Source instance
@@ -55,63 +111,122 @@ func Attach(ctx context.Context, spec *source.Spec) (*source.Source, error) {
 	}
 
 	// Start streaming output into the channel
-	st := stream(rp, src)
+	st := stream(src, pipes...)
+	startedAt := time.Now()
 
 	// Wait out the process in a goroutine
 	go func(grace time.Duration) {
 		defer close(src.Done)
 
-		// TODO report this error?
+		// A non-zero status is reported through the ProcessState; as the
+		// child writes straight to the pipes there are no copying errors.
 		_ = cmd.Wait()
+		runtime := time.Since(startedAt)
+
+		// Call off the SIGKILL once the group is gone: after reaping it,
+		// or now if nothing outlived the child.
+		pgid := cmd.Process.Pid
+		switch {
+		case subreaper.Load():
+			go func() {
+				reapGroup(pgid)
+				if kill != nil {
+					kill.Stop()
+				}
+			}()
+		case kill != nil && !groupAlive(pgid):
+			kill.Stop()
+		}
+		// The child exited on its own, but may have left something behind
+		// in its group, like a command started with `&`. That goes with the
+		// source.
+		if kill == nil && groupAlive(pgid) {
+			context.AfterFunc(ctx, func() {
+				if groupAlive(pgid) {
+					stopOrphans(pgid, stopSignal, stopTimeout)
+				}
+			})
+		}
 
 		// Drain pipe with 1 second of grace, then shut it down and wait for
-		// streaming Done signal.
+		// streaming Done signal. This applies to a stopped process too, so
+		// whatever it wrote while shutting down is still delivered.
+		timer := time.NewTimer(grace)
+		defer timer.Stop()
 		select {
-		case <-ctx.Done():
+		case <-st.Done:
+			// Nothing
+		case <-timer.C:
 			close(st.Stop)
-		default:
-			timer := time.NewTimer(grace)
-			defer timer.Stop()
-			select {
-			case <-st.Done:
-				// Nothing
-			case <-timer.C:
-				close(st.Stop)
-			}
 		}
 
 		<-st.Done
-		// TODO possibly build a "exit status" for the source
+		src.Exit = exitStatus(cmd.ProcessState, runtime)
 	}(streamGracePeriod)
 
 	return src, nil
 }
 
+// Build the exit status of a process that has been waited on.
+func exitStatus(state *os.ProcessState, runtime time.Duration) *source.Exit {
+	exit := &source.Exit{
+		Status:   state.ExitCode(),
+		Runtime:  runtime,
+		UserTime: state.UserTime(),
+		SysTime:  state.SystemTime(),
+	}
+
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		exit.Signal = ws.Signal()
+		exit.Status = 128 + int(exit.Signal)
+	}
+
+	// ru_maxrss is in kilobytes on Linux.
+	if ru, ok := state.SysUsage().(*syscall.Rusage); ok {
+		exit.MaxRSS = ru.Maxrss * 1024
+	}
+
+	return exit
+}
+
 // Controls for a process Streaming goroutines.
 type StreamCtl struct {
-	// Signals that streaming is done. Owned and closed by the reading
-	// goroutine.
+	// Signals that streaming is done. Closed once every reading goroutine
+	// has exited.
 	Done chan struct{}
 	// Preempts the streaming goroutines and closes the pipe when closed.
 	Stop chan struct{}
 }
 
-func stream(pipe io.ReadCloser, src *source.Source) *StreamCtl {
+// The read end of a pipe from the child, and the stream it carries.
+type pipe struct {
+	r      io.ReadCloser
+	stream source.Stream
+}
+
+func stream(src *source.Source, pipes ...pipe) *StreamCtl {
 	st := &StreamCtl{
 		Done: make(chan struct{}),
 		Stop: make(chan struct{}),
 	}
 
-	go read(pipe, src, st)
-	go cleanup(pipe, src.Out, st)
+	var wg sync.WaitGroup
+	closers := make([]io.Closer, 0, len(pipes))
+	for _, p := range pipes {
+		wg.Go(func() { read(p.r, p.stream, src, st) })
+		closers = append(closers, p.r)
+	}
+
+	go func() {
+		wg.Wait()
+		close(st.Done)
+	}()
+	go cleanup(closers, src.Out, st)
 
 	return st
 }
 
-func read(pipe io.Reader, src *source.Source, ctl *StreamCtl) {
-	// Signal that streaming is done.
-	defer close(ctl.Done)
-
+func read(pipe io.Reader, stream source.Stream, src *source.Source, ctl *StreamCtl) {
 	rd := bufio.NewReaderSize(pipe, source.MaxLineLength)
 	for {
 		// This call blocks until the pipe is closed. Includes delimiter.
@@ -127,7 +242,13 @@ func read(pipe io.Reader, src *source.Source, ctl *StreamCtl) {
 			cp := make([]byte, n)
 			copy(cp, bytes[:n])
 
-			msg := source.Output{Id: src.Id, CapturedAt: time.Now(), Bytes: cp}
+			msg := source.Output{
+				Id:         src.Id,
+				Kind:       src.Kind,
+				CapturedAt: time.Now(),
+				Stream:     stream,
+				Bytes:      cp,
+			}
 
 			// Preempt writing if a Stop signal arrived.
 			select {
@@ -153,17 +274,23 @@ func read(pipe io.Reader, src *source.Source, ctl *StreamCtl) {
 	}
 }
 
-// Close the pipe and out channel.
-func cleanup(pipe io.Closer, out chan source.Output, st *StreamCtl) {
+// Close the pipes and out channel.
+func cleanup(pipes []io.Closer, out chan source.Output, st *StreamCtl) {
 	defer close(out)
 	select {
-	// Streaming goroutine exited on its own. Close the pipe and get out.
+	// Streaming goroutines exited on their own. Close the pipes and get out.
 	case <-st.Done:
-		_ = pipe.Close()
-	// Stop signal arrived. Close the pipe to unblock a ReadBytes(), then wait
-	// for the streaming goroutine to be done.
+		closeAll(pipes)
+	// Stop signal arrived. Close the pipes to unblock a ReadBytes(), then
+	// wait for the streaming goroutines to be done.
 	case <-st.Stop:
-		_ = pipe.Close()
+		closeAll(pipes)
 		<-st.Done
 	}
 }
+
+func closeAll(closers []io.Closer) {
+	for _, c := range closers {
+		_ = c.Close()
+	}
+}
@@ -1,10 +1,16 @@
 package proc
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"os"
+	"os/exec"
 	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -40,8 +46,6 @@ func collect(lineC chan []byte, deadline time.Duration) [][]byte {
 			return lines
 		}
 	}
-
-	return lines
 }
 
 func Map[T any, R any](xs []T, f func(T) R) []R {
@@ -287,3 +291,336 @@ func TestAttachProc_ChildKeepsPipeOpen(t *testing.T) {
 		t.Fatalf("timeout expired")
 	}
 }
+
+func TestAttachProc_MergesStderr(t *testing.T) {
+	ctx := t.Context()
+	spec := NewSpec("merge", "sh", []string{"-c", "echo out; echo err >&2"})
+
+	src, err := Attach(ctx, spec)
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	var msgs []source.Output
+	for msg := range src.Out {
+		msgs = append(msgs, msg)
+	}
+
+	if len(msgs) != 2 {
+		t.Fatalf("wrong number of messages: %d, want 2", len(msgs))
+	}
+
+	for _, msg := range msgs {
+		if msg.Stream != source.StreamStdout {
+			t.Fatalf("unexpected stream %s for %q", msg.Stream, msg.Bytes)
+		}
+	}
+}
+
+func TestAttachProc_TagsStderr(t *testing.T) {
+	ctx := t.Context()
+	spec := NewSpec("tag", "sh", []string{"-c", "echo out; echo err >&2"})
+	spec.Stderr = source.StderrTag
+
+	src, err := Attach(ctx, spec)
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	streams := make(map[string]source.Stream)
+	for msg := range src.Out {
+		streams[string(msg.Bytes)] = msg.Stream
+	}
+
+	if len(streams) != 2 {
+		t.Fatalf("wrong number of messages: %v", streams)
+	}
+
+	if streams["out"] != source.StreamStdout {
+		t.Fatal("stdout line on stream", streams["out"])
+	}
+
+	if streams["err"] != source.StreamStderr {
+		t.Fatal("stderr line on stream", streams["err"])
+	}
+}
+
+func TestAttachProc_ExitStatus(t *testing.T) {
+	ctx := t.Context()
+	spec := NewSpec("exit", "sh", []string{"-c", "exit 3"})
+
+	src, err := Attach(ctx, spec)
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	for range src.Out {
+	}
+	<-src.Done
+
+	if src.Exit == nil {
+		t.Fatal("exit status not set")
+	}
+
+	if src.Exit.Status != 3 {
+		t.Fatal("Status =", src.Exit.Status, "wanted 3")
+	}
+
+	if src.Exit.Signal != 0 {
+		t.Fatal("unexpected signal:", src.Exit.Signal)
+	}
+
+	if src.Exit.Runtime <= 0 {
+		t.Fatal("runtime not measured:", src.Exit.Runtime)
+	}
+}
+
+func TestAttachProc_ExitSignal(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	spec := NewSpec("killed", "sleep", []string{"10"})
+	spec.StopSignal = syscall.SIGKILL
+
+	src, err := Attach(ctx, spec)
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	cancel()
+
+	select {
+	case <-src.Done:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout expired")
+	}
+
+	if src.Exit == nil {
+		t.Fatal("exit status not set")
+	}
+
+	if src.Exit.Signal != syscall.SIGKILL {
+		t.Fatal("Signal =", src.Exit.Signal, "wanted SIGKILL")
+	}
+
+	if src.Exit.Status != 137 {
+		t.Fatal("Status =", src.Exit.Status, "wanted 137")
+	}
+
+	if !strings.HasPrefix(src.Exit.String(), "status=137 signal=KILL after ") {
+		t.Fatalf("unexpected summary: '%s'", src.Exit.String())
+	}
+}
+
+// Whether pid names a live process; zombies count as dead.
+func alive(pid int) bool {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// The state follows the parenthesized command name.
+	i := bytes.LastIndexByte(stat, ')')
+	return i != -1 && i+2 < len(stat) && stat[i+2] != 'Z'
+}
+
+func TestAttachProc_CancelKillsGroup(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	// sh reports the pid of its background child, then waits for it.
+	spec := NewSpec("group", "sh", []string{"-c", "sleep 100 & echo $!; wait"})
+
+	src, err := Attach(ctx, spec)
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	var out source.Output
+	select {
+	case out = <-src.Out:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for pid")
+	}
+
+	pid, err := strconv.Atoi(string(out.Bytes))
+	if err != nil {
+		t.Fatalf("bad pid %q: %v", out.Bytes, err)
+	}
+
+	cancel()
+
+	select {
+	case <-src.Done:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout expired")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for alive(pid) {
+		if time.Now().After(deadline) {
+			t.Fatalf("grandchild %d survived", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAttachProc_CancelStopsOrphans(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	// sh leaves its background child behind in the group and exits.
+	spec := NewSpec("orphans", "sh", []string{"-c", "sleep 100 >/dev/null & echo $!"})
+
+	src, err := Attach(ctx, spec)
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	var out source.Output
+	select {
+	case out = <-src.Out:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for pid")
+	}
+	pid, err := strconv.Atoi(string(out.Bytes))
+	if err != nil {
+		t.Fatalf("bad pid %q: %v", out.Bytes, err)
+	}
+	defer syscall.Kill(pid, syscall.SIGKILL)
+
+	select {
+	case <-src.Done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for sh to exit")
+	}
+	if !alive(pid) {
+		t.Fatalf("grandchild %d gone before the source was cancelled", pid)
+	}
+
+	cancel()
+
+	deadline := time.Now().Add(time.Second)
+	for alive(pid) {
+		if time.Now().After(deadline) {
+			t.Fatalf("grandchild %d survived", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAttachProc_StopSignal(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	spec := NewSpec("term", "sleep", []string{"10"})
+	spec.StopSignal = syscall.SIGTERM
+
+	src, err := Attach(ctx, spec)
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	cancel()
+
+	select {
+	case <-src.Done:
+	case <-time.After(time.Second):
+		t.Fatalf("timeout expired")
+	}
+
+	if src.Exit.Signal != syscall.SIGTERM {
+		t.Fatal("Signal =", src.Exit.Signal, "wanted SIGTERM")
+	}
+}
+
+func TestAttachProc_StopTimeoutKills(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	// Ignore SIGTERM; only the SIGKILL after the timeout stops it.
+	spec := NewSpec("stubborn", "sh", []string{"-c", "trap '' TERM; echo ready; sleep 10"})
+	spec.StopSignal = syscall.SIGTERM
+	spec.StopTimeout = 100 * time.Millisecond
+
+	src, err := Attach(ctx, spec)
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	select {
+	case <-src.Out:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for trap")
+	}
+
+	cancel()
+
+	select {
+	case <-src.Done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout expired")
+	}
+
+	if src.Exit.Signal != syscall.SIGKILL {
+		t.Fatal("Signal =", src.Exit.Signal, "wanted SIGKILL")
+	}
+}
+
+func TestStopGroup_StoppedTimerSparesGroup(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "trap '' TERM; echo ready; sleep 10")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("StdoutPipe: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	pgid := cmd.Process.Pid
+	defer func() {
+		_ = syscall.Kill(-pgid, syscall.SIGKILL)
+		_ = cmd.Wait()
+	}()
+
+	// The trap is set once it says so.
+	if _, err := stdout.Read(make([]byte, 16)); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	kill, err := stopGroup(pgid, syscall.SIGTERM, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("stopGroup: %v", err)
+	}
+	if !kill.Stop() {
+		t.Fatal("SIGKILL already sent")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if !groupAlive(pgid) || !alive(pgid) {
+		t.Fatal("group killed after the timer was stopped")
+	}
+}
+
+func TestAttachProc_DeliversShutdownOutput(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	script := "trap 'echo flushing; exit 0' TERM; echo ready; while :; do sleep 0.01; done"
+	spec := NewSpec("graceful", "sh", []string{"-c", script})
+
+	src, err := Attach(ctx, spec)
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+
+	outC := make(chan []byte, 16)
+	go consume(t.Context(), src, outC)
+
+	select {
+	case <-outC:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for trap")
+	}
+
+	cancel()
+
+	// The shell may also report its sleep child as terminated.
+	lines := collect(outC, 2*time.Second)
+	if !slices.ContainsFunc(lines, func(l []byte) bool { return string(l) == "flushing" }) {
+		t.Fatalf("lines: %q; want \"flushing\"", lines)
+	}
+
+	<-src.Done
+	if src.Exit.Status != 0 {
+		t.Fatal("Status =", src.Exit.Status, "wanted 0")
+	}
+}
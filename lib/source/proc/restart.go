@@ -0,0 +1,72 @@
+package proc
+
+import (
+	"time"
+
+	"github.com/mdsn/gather/lib/source"
+)
+
+// Decides whether and when a proc source is restarted after its process
+// exits, according to its restart policy.
+type Restarter struct {
+	policy source.Restart
+	// Delay before the next restart.
+	backoff time.Duration
+	// Times of the restarts within the current window.
+	restarts []time.Time
+}
+
+func NewRestarter(policy source.Restart) *Restarter {
+	if policy.Backoff <= 0 {
+		policy.Backoff = source.DefaultRestartBackoff
+	}
+	if policy.MaxBackoff < policy.Backoff {
+		policy.MaxBackoff = max(policy.Backoff, source.DefaultRestartMaxBackoff)
+	}
+	if policy.Window <= 0 {
+		policy.Window = source.DefaultRestartWindow
+	}
+	return &Restarter{policy: policy, backoff: policy.Backoff}
+}
+
+// The restart policy, with defaults filled in.
+func (r *Restarter) Policy() source.Restart {
+	return r.policy
+}
+
+// Return the delay before restarting a process that exited as described by
+// exit at the given time, and whether to restart it at all. A process that
+// ran for longer than the maximum backoff is considered healthy, and the
+// backoff starts over from its initial value.
+func (r *Restarter) Next(exit *source.Exit, now time.Time) (time.Duration, bool) {
+	switch r.policy.Mode {
+	case source.RestartNever:
+		return 0, false
+	case source.RestartOnFailure:
+		if exit == nil || exit.Status == 0 {
+			return 0, false
+		}
+	}
+
+	// Forget restarts that fell out of the window.
+	cutoff := now.Add(-r.policy.Window)
+	i := 0
+	for i < len(r.restarts) && r.restarts[i].Before(cutoff) {
+		i++
+	}
+	r.restarts = r.restarts[i:]
+
+	if r.policy.MaxRestarts > 0 && len(r.restarts) >= r.policy.MaxRestarts {
+		return 0, false
+	}
+
+	if exit != nil && exit.Runtime > r.policy.MaxBackoff {
+		r.backoff = r.policy.Backoff
+	}
+
+	delay := r.backoff
+	r.backoff = min(2*r.backoff, r.policy.MaxBackoff)
+	r.restarts = append(r.restarts, now.Add(delay))
+
+	return delay, true
+}
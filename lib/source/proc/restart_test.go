@@ -0,0 +1,86 @@
+package proc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mdsn/gather/lib/source"
+)
+
+func TestRestarter_Never(t *testing.T) {
+	r := NewRestarter(source.Restart{Mode: source.RestartNever})
+	if _, ok := r.Next(&source.Exit{Status: 1}, time.Now()); ok {
+		t.Fatal("restarted with policy never")
+	}
+}
+
+func TestRestarter_OnFailure(t *testing.T) {
+	r := NewRestarter(source.Restart{Mode: source.RestartOnFailure})
+	now := time.Now()
+
+	if _, ok := r.Next(&source.Exit{Status: 0}, now); ok {
+		t.Fatal("restarted after success")
+	}
+
+	if _, ok := r.Next(&source.Exit{Status: 1}, now); !ok {
+		t.Fatal("not restarted after failure")
+	}
+
+	if _, ok := r.Next(&source.Exit{Status: 137, Signal: 9}, now); !ok {
+		t.Fatal("not restarted after signal")
+	}
+}
+
+func TestRestarter_ExponentialBackoff(t *testing.T) {
+	r := NewRestarter(source.Restart{
+		Mode:       source.RestartAlways,
+		Backoff:    time.Second,
+		MaxBackoff: 5 * time.Second,
+	})
+	now := time.Now()
+	exit := &source.Exit{Runtime: time.Millisecond}
+
+	want := []time.Duration{1, 2, 4, 5, 5}
+	for i, w := range want {
+		delay, ok := r.Next(exit, now)
+		if !ok {
+			t.Fatal("not restarted at", i)
+		}
+		if delay != w*time.Second {
+			t.Fatalf("restart %d: delay = %s, want %s", i, delay, w*time.Second)
+		}
+	}
+
+	// A long run resets the backoff.
+	delay, _ := r.Next(&source.Exit{Runtime: time.Minute}, now)
+	if delay != time.Second {
+		t.Fatal("backoff not reset:", delay)
+	}
+}
+
+func TestRestarter_MaxRestartsInWindow(t *testing.T) {
+	r := NewRestarter(source.Restart{
+		Mode:        source.RestartAlways,
+		Backoff:     time.Second,
+		MaxBackoff:  time.Second,
+		MaxRestarts: 2,
+		Window:      time.Minute,
+	})
+	now := time.Now()
+	exit := &source.Exit{}
+
+	for i := range 2 {
+		if _, ok := r.Next(exit, now); !ok {
+			t.Fatal("not restarted at", i)
+		}
+	}
+
+	if _, ok := r.Next(exit, now); ok {
+		t.Fatal("restarted past the limit")
+	}
+
+	// Once the earlier restarts fall out of the window, restarts resume.
+	if _, ok := r.Next(exit, now.Add(2*time.Minute)); !ok {
+		t.Fatal("not restarted after the window passed")
+	}
+}
@@ -2,6 +2,9 @@ package source
 
 import (
 	"context"
+	"path"
+	"slices"
+	"syscall"
 	"time"
 )
 
@@ -14,12 +17,96 @@ type SourceKind uint8
 const (
 	KindFile SourceKind = iota
 	KindProc
+	// A directory whose files are attached as file sources of their own.
+	KindDir
 )
 
+func (k SourceKind) String() string {
+	switch k {
+	case KindFile:
+		return "file"
+	case KindProc:
+		return "proc"
+	case KindDir:
+		return "dir"
+	default:
+		return "unknown"
+	}
+}
+
+// Whether the reading goroutine of a source is still alive.
+type State uint8
+
+const (
+	StateRunning State = iota
+	StateExited
+	// Exited, and waiting out a backoff before being started again.
+	StateRestarting
+	// Running, but its output is held back until it is resumed.
+	StatePaused
+)
+
+func (s State) String() string {
+	switch s {
+	case StateRunning:
+		return "running"
+	case StateExited:
+		return "exited"
+	case StateRestarting:
+		return "restarting"
+	case StatePaused:
+		return "paused"
+	default:
+		return "unknown"
+	}
+}
+
+// The output stream a line was read from. File sources only ever produce
+// StreamStdout.
+type Stream uint8
+
+const (
+	StreamStdout Stream = iota
+	StreamStderr
+	// Lifecycle events about the source itself, like a process exiting.
+	StreamEvent
+)
+
+func (s Stream) String() string {
+	switch s {
+	case StreamStdout:
+		return "stdout"
+	case StreamStderr:
+		return "stderr"
+	case StreamEvent:
+		return "event"
+	default:
+		return "unknown"
+	}
+}
+
 type Output struct {
 	Id         string
+	Kind       SourceKind
 	CapturedAt time.Time
+	Stream     Stream
 	Bytes      []byte
+	// Set on the StreamEvent reporting that a process exited.
+	Exit *Exit
+}
+
+// Whether id matches any of the glob patterns, as in path.Match. An empty
+// list of patterns matches every id.
+func MatchId(patterns []string, id string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, id); ok {
+			return true
+		}
+	}
+	return false
 }
 
 type Source struct {
@@ -29,26 +116,317 @@ type Source struct {
 	Done chan struct{}
 	// Closed when reading goroutine is ready to read.
 	Ready chan struct{}
-	// Output is sent on this channel.
+	// Output is sent on this channel. Closed once the source sends no more.
 	Out chan Output
 	Err chan error
 	// Terminates the execution of this source.
 	Cancel context.CancelFunc
+	// How the process of a proc source ended. Set before Done is closed.
+	Exit *Exit
+	// Stops and starts reading. Only file sources have one; proc sources
+	// are paused by whoever reads Out.
+	Control chan Control
+}
+
+// A request to a file source to stop or start reading.
+type Control struct {
+	Pause bool
+	// On resume, jump to EOF rather than read what was written meanwhile.
+	Skip bool
 }
 
-func (src *Source) Send(b []byte) {
+// The State of the source, as given by its Done channel.
+func (src *Source) State() State {
+	select {
+	case <-src.Done:
+		return StateExited
+	default:
+		return StateRunning
+	}
+}
+
+// Send a copy of b on the Out channel. Returns false if ctx was done before
+// anyone received it.
+func (src *Source) Send(ctx context.Context, b []byte) bool {
 	buf := make([]byte, len(b))
 	copy(buf, b)
-	src.Out <- Output{
+	select {
+	case src.Out <- Output{
 		Id:         src.Id,
+		Kind:       src.Kind,
 		CapturedAt: time.Now(),
 		Bytes:      buf,
+	}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Send a lifecycle event about the source with the given message. Returns
+// false if ctx was done before anyone received it.
+func (src *Source) Event(ctx context.Context, msg string) bool {
+	select {
+	case src.Out <- Output{
+		Id:         src.Id,
+		Kind:       src.Kind,
+		CapturedAt: time.Now(),
+		Stream:     StreamEvent,
+		Bytes:      []byte(msg),
+	}:
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
 
+// How a file source keeps track of the file it tails.
+type FollowMode uint8
+
+const (
+	// Follow the open file descriptor, wherever the inode ends up.
+	FollowDescriptor FollowMode = iota
+	// Follow the path, reopening it when another file takes its place.
+	FollowName
+)
+
+func (f FollowMode) String() string {
+	switch f {
+	case FollowDescriptor:
+		return "descriptor"
+	case FollowName:
+		return "name"
+	default:
+		return "unknown"
+	}
+}
+
+// How a file source learns that its file changed.
+type WatchMode uint8
+
+const (
+	// Poll on filesystems inotify does not work on, like NFS or FUSE, and
+	// use inotify everywhere else.
+	WatchAuto WatchMode = iota
+	WatchInotify
+	// Look at the file every so often.
+	WatchPoll
+)
+
+func (w WatchMode) String() string {
+	switch w {
+	case WatchAuto:
+		return "auto"
+	case WatchInotify:
+		return "inotify"
+	case WatchPoll:
+		return "poll"
+	default:
+		return "unknown"
+	}
+}
+
+// Where a file source starts reading when it is attached.
+type StartMode uint8
+
+const (
+	// Only lines written after the source is attached.
+	StartEnd StartMode = iota
+	// The whole file.
+	StartBeginning
+	// From a byte offset, or EOF if the file is shorter.
+	StartOffset
+	// The last lines of the file, like `tail -n`.
+	StartLines
+)
+
+func (m StartMode) String() string {
+	switch m {
+	case StartEnd:
+		return "end"
+	case StartBeginning:
+		return "start"
+	case StartOffset:
+		return "offset"
+	case StartLines:
+		return "lines"
+	default:
+		return "unknown"
+	}
+}
+
+type Start struct {
+	Mode StartMode
+	// Byte offset for StartOffset.
+	Offset int64
+	// Number of lines for StartLines.
+	Lines int
+}
+
+// Where a file source starts when its checkpoint turns out to be for a
+// different file than the one at its path.
+type Fallback uint8
+
+const (
+	// The starting point given by Start, as if there was no checkpoint.
+	FallbackFrom Fallback = iota
+	// The beginning of the file, which is likely new.
+	FallbackBeginning
+	// EOF.
+	FallbackEnd
+)
+
+func (f Fallback) String() string {
+	switch f {
+	case FallbackFrom:
+		return "from"
+	case FallbackBeginning:
+		return "start"
+	case FallbackEnd:
+		return "end"
+	default:
+		return "unknown"
+	}
+}
+
+// What a proc source does with the stderr of its process.
+type StderrMode uint8
+
+const (
+	// Write stderr to the same pipe as stdout; lines are indistinguishable.
+	StderrMerge StderrMode = iota
+	// Read stderr separately and mark its lines with StreamStderr.
+	StderrTag
+)
+
+func (m StderrMode) String() string {
+	switch m {
+	case StderrMerge:
+		return "merge"
+	case StderrTag:
+		return "tag"
+	default:
+		return "unknown"
+	}
+}
+
+// When a proc source is started again after its process exits.
+type RestartMode uint8
+
+const (
+	RestartNever RestartMode = iota
+	// Only after a non-zero exit status, including termination by signal.
+	RestartOnFailure
+	RestartAlways
+)
+
+func (m RestartMode) String() string {
+	switch m {
+	case RestartNever:
+		return "never"
+	case RestartOnFailure:
+		return "on-failure"
+	case RestartAlways:
+		return "always"
+	default:
+		return "unknown"
+	}
+}
+
+// Restart policy for a proc source. The delay before each restart starts at
+// Backoff and doubles up to MaxBackoff. At most MaxRestarts restarts happen
+// within any Window; zero means no limit.
+type Restart struct {
+	Mode        RestartMode
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	MaxRestarts int
+	Window      time.Duration
+}
+
+const (
+	DefaultRestartBackoff    = time.Second
+	DefaultRestartMaxBackoff = time.Minute
+	DefaultRestartWindow     = 10 * time.Minute
+)
+
+// Files a dir source attaches at most, unless told otherwise.
+const DefaultMaxFiles = 100
+
+const (
+	DefaultStopSignal  = syscall.SIGTERM
+	DefaultStopTimeout = 5 * time.Second
+)
+
+// What a paused proc source does with the output of its process, which
+// keeps running. File sources stop reading instead, and keep their offset.
+type Pause struct {
+	// Drop the output rather than buffer it.
+	Discard bool
+	// Lines buffered before the oldest ones are dropped. Zero means
+	// DefaultPauseBuffer.
+	Buffer int
+}
+
+const DefaultPauseBuffer = 1000
+
+type Resume struct {
+	// Skip whatever was written while paused: file sources jump to EOF and
+	// proc sources drop their buffer.
+	Skip bool
+}
+
 type Spec struct {
 	Id   string
 	Kind SourceKind
 	Path string
 	Args []string
+	// File sources only.
+	Follow FollowMode
+	Start  Start
+	Watch  WatchMode
+	// Directory where the offset is checkpointed, to resume from it when a
+	// source with the same id is attached again. Empty disables it.
+	StateDir string
+	Fallback Fallback
+	// Dir sources only. The files matching Glob, as in path.Match, are
+	// attached as file sources; at most MaxFiles of them at once.
+	Glob     string
+	MaxFiles int
+	// Levels of subdirectories watched below Path; zero watches Path alone.
+	MaxDepth int
+	// Patterns files must match one of, and files and subdirectories must
+	// match none of. A pattern without a slash is matched against the name,
+	// one with a slash against the path relative to Path.
+	Include []string
+	Exclude []string
+	// Proc sources only.
+	Stderr  StderrMode
+	Restart Restart
+	// Sent to the process group on removal. SIGKILL follows StopTimeout
+	// later for anything left in the group.
+	StopSignal  syscall.Signal
+	StopTimeout time.Duration
+}
+
+// Whether two specs describe the same source, down to every option.
+func (s *Spec) Equal(o *Spec) bool {
+	return s.Id == o.Id &&
+		s.Kind == o.Kind &&
+		s.Path == o.Path &&
+		slices.Equal(s.Args, o.Args) &&
+		s.Follow == o.Follow &&
+		s.Start == o.Start &&
+		s.Watch == o.Watch &&
+		s.StateDir == o.StateDir &&
+		s.Fallback == o.Fallback &&
+		s.Glob == o.Glob &&
+		s.MaxFiles == o.MaxFiles &&
+		s.MaxDepth == o.MaxDepth &&
+		slices.Equal(s.Include, o.Include) &&
+		slices.Equal(s.Exclude, o.Exclude) &&
+		s.Stderr == o.Stderr &&
+		s.Restart == o.Restart &&
+		s.StopSignal == o.StopSignal &&
+		s.StopTimeout == o.StopTimeout
 }
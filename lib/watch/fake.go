@@ -0,0 +1,103 @@
+package watch
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// A Watcher that watches nothing, for tests. Paths need not exist; the events
+// of a watch are whatever is passed to Notify for its path.
+type Fake struct {
+	// synchronizes access to watches and closed
+	mu sync.Mutex
+	// watches keyed by a descriptor of their own
+	watches map[int]*Watch
+	next    int
+	closed  bool
+}
+
+func NewFake() *Fake {
+	return &Fake{watches: make(map[int]*Watch)}
+}
+
+func (f *Fake) Add(path string) (*WatchHandle, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, errors.New("watcher closed")
+	}
+	w := newWatch(path, 0)
+	f.next++
+	f.watches[f.next] = w
+	return &WatchHandle{wd: f.next, w: w, Out: w.out}, nil
+}
+
+func (f *Fake) Rm(handle *WatchHandle) error {
+	f.mu.Lock()
+	if _, ok := f.watches[handle.wd]; !ok {
+		f.mu.Unlock()
+		return errors.New("watch not found")
+	}
+	delete(f.watches, handle.wd)
+	f.mu.Unlock()
+
+	handle.w.close()
+	return nil
+}
+
+// Remove every watch. Their Out channels are closed.
+func (f *Fake) Close() error {
+	f.mu.Lock()
+	f.closed = true
+	ws := slices.Collect(maps.Values(f.watches))
+	clear(f.watches)
+	f.mu.Unlock()
+
+	for _, w := range ws {
+		w.close()
+	}
+	return nil
+}
+
+// Send ev to every watch on path, in the order they were added. Returns once
+// each one has received it or was removed, with how many received it.
+func (f *Fake) Notify(path string, ev Event) int {
+	n := 0
+	for _, w := range f.watching(path) {
+		if w.send(ev) {
+			n++
+		}
+	}
+	return n
+}
+
+// Send an EventOverflow to every watch, as inotify does when events are lost.
+func (f *Fake) Overflow() int {
+	n := 0
+	for _, w := range f.watching("") {
+		if w.send(Event{Kind: EventOverflow}) {
+			n++
+		}
+	}
+	return n
+}
+
+// How many watches there are on path.
+func (f *Fake) Watching(path string) int {
+	return len(f.watching(path))
+}
+
+// The watches on path, or every watch if path is empty.
+func (f *Fake) watching(path string) []*Watch {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ws []*Watch
+	for _, wd := range slices.Sorted(maps.Keys(f.watches)) {
+		if w := f.watches[wd]; path == "" || w.path == path {
+			ws = append(ws, w)
+		}
+	}
+	return ws
+}
@@ -4,8 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"os"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
@@ -25,26 +28,13 @@ import (
 const (
 	InotifyBufferSize = 4096
 	InotifyMask       = unix.IN_MODIFY
+	// Mask for watching a directory for files appearing in it, either
+	// created or moved in from elsewhere.
+	InotifyDirMask = unix.IN_CREATE | unix.IN_MOVED_TO
+	// Mask for watching a directory for files appearing in or leaving it.
+	InotifyDirChangeMask = InotifyDirMask | unix.IN_DELETE | unix.IN_MOVED_FROM
 )
 
-type Event struct {
-	Wd     int32
-	Cookie uint32
-	Mask   uint32
-	Name   string
-}
-
-type Watch struct {
-	path   string
-	offset int
-	out    chan Event
-}
-
-type WatchHandle struct {
-	wd  int
-	Out chan Event
-}
-
 type Inotify struct {
 	// synchronizes access to wds
 	mu sync.Mutex
@@ -58,10 +48,13 @@ type Inotify struct {
 	evfd int
 	// epoll descriptor
 	epfd int
-	// watches keyed by watch descriptor
-	wds map[int]*Watch
+	// watches keyed by watch descriptor. The kernel hands out the same
+	// descriptor for every watch on an inode, so there may be several.
+	wds map[int][]*Watch
 	// Indicates inotifyReceive goroutine exited
 	done chan struct{}
+	// Times the kernel event queue overflowed.
+	overflows atomic.Uint64
 }
 
 func NewInotify() (*Inotify, error) {
@@ -100,7 +93,7 @@ func NewInotify() (*Inotify, error) {
 		ifd:  ifd,
 		evfd: evfd,
 		epfd: epfd,
-		wds:  make(map[int]*Watch),
+		wds:  make(map[int][]*Watch),
 		done: make(chan struct{}),
 	}
 	ino.wg.Go(func() { inotifyReceive(ino) })
@@ -108,6 +101,7 @@ func NewInotify() (*Inotify, error) {
 	return ino, nil
 }
 
+// Stop every watch. Their Out channels are closed.
 func (ino *Inotify) Close() error {
 	var err error
 	ino.once.Do(func() {
@@ -122,6 +116,19 @@ func (ino *Inotify) Close() error {
 
 		ino.wg.Wait() // Wait for inotifyReceive to wrap up.
 
+		// Forget the watches, so that a later Rm() leaves the closed
+		// descriptor alone; its number may belong to someone else by then.
+		ino.mu.Lock()
+		var ws []*Watch
+		for _, wds := range ino.wds {
+			ws = append(ws, wds...)
+		}
+		clear(ino.wds)
+		ino.mu.Unlock()
+		for _, w := range ws {
+			w.close()
+		}
+
 		err = errors.Join(
 			unix.Close(ino.ifd),
 			unix.Close(ino.evfd),
@@ -130,40 +137,68 @@ func (ino *Inotify) Close() error {
 	return err
 }
 
+// Watch a file for modifications, or a directory for files appearing in and
+// leaving it.
 func (ino *Inotify) Add(path string) (*WatchHandle, error) {
-	wd, err := unix.InotifyAddWatch(ino.ifd, path, InotifyMask)
+	st, err := os.Stat(path)
 	if err != nil {
 		return nil, err
 	}
-	outC := make(chan Event)
+	if st.IsDir() {
+		return ino.AddMask(path, InotifyDirChangeMask)
+	}
+	return ino.AddMask(path, InotifyMask)
+}
 
+// Add a watch on path for the events in mask. Events on the same inode are
+// delivered to every watch interested in them.
+func (ino *Inotify) AddMask(path string, mask uint32) (*WatchHandle, error) {
+	// Held across the syscall so that Rm() cannot remove the kernel watch
+	// between it handing out wd and the watch being added under it.
 	ino.mu.Lock()
-	ino.wds[wd] = &Watch{
-		path: path,
-		out:  outC,
+	defer ino.mu.Unlock()
+
+	// IN_MASK_ADD keeps the events requested by other watches on the inode.
+	wd, err := unix.InotifyAddWatch(ino.ifd, path, mask|unix.IN_MASK_ADD)
+	if err != nil {
+		return nil, err
 	}
-	ino.mu.Unlock()
+	w := newWatch(path, mask)
+	ino.wds[wd] = append(ino.wds[wd], w)
 
-	return &WatchHandle{wd: wd, Out: outC}, nil
+	return &WatchHandle{wd: wd, w: w, Out: w.out}, nil
 }
 
 func (ino *Inotify) Rm(handle *WatchHandle) error {
-	defer close(handle.Out)
-
-	if _, ok := ino.wds[handle.wd]; !ok {
+	ino.mu.Lock()
+	ws := ino.wds[handle.wd]
+	i := slices.Index(ws, handle.w)
+	if i == -1 {
+		ino.mu.Unlock()
 		return errors.New("watch not found")
 	}
 
-	ino.mu.Lock()
-	delete(ino.wds, handle.wd)
+	ws = slices.Delete(ws, i, i+1)
+	var err error
+	if len(ws) > 0 {
+		// Other watches remain on the inode; leave the kernel watch alone.
+		ino.wds[handle.wd] = ws
+	} else {
+		delete(ino.wds, handle.wd)
+		// Still holding mu, so that AddMask() does not add a watch under
+		// wd just before the kernel watch goes away.
+		// XXX Does `success` have any use here?
+		_, err = unix.InotifyRmWatch(ino.ifd, uint32(handle.wd))
+	}
 	ino.mu.Unlock()
 
-	// XXX Does `success` have any use here?
-	_, err := unix.InotifyRmWatch(ino.ifd, uint32(handle.wd))
-	if err != nil {
-		return err
-	}
-	return nil
+	handle.w.close()
+	return err
+}
+
+// Times the kernel event queue overflowed since ino was created.
+func (ino *Inotify) Overflows() uint64 {
+	return ino.overflows.Load()
 }
 
 func inotifyReceive(ino *Inotify) {
@@ -223,21 +258,64 @@ func inotifyReceive(ino *Inotify) {
 				continue
 			}
 
-			// XXX contention?
+			// Events were lost; every watch has to catch up on its own.
+			if event.Wd == -1 && event.Mask&unix.IN_Q_OVERFLOW != 0 {
+				ino.overflows.Add(1)
+				log.Printf("inotify: event queue overflowed, events were lost; rescanning")
+				ino.rescan()
+				continue
+			}
+
 			ino.mu.Lock()
-			if w, ok := ino.wds[int(event.Wd)]; ok {
-				w.out <- Event{
+			ws := slices.Clone(ino.wds[int(event.Wd)])
+			ino.mu.Unlock()
+
+			for _, w := range ws {
+				if event.Mask&w.mask == 0 {
+					continue
+				}
+				w.send(Event{
+					Kind:   inotifyKind(event.Mask),
+					Dir:    event.Mask&unix.IN_ISDIR != 0,
 					Wd:     event.Wd,
 					Cookie: event.Cookie,
 					Mask:   event.Mask,
 					Name:   name,
-				}
+				})
 			}
-			ino.mu.Unlock()
 		}
 	}
 }
 
+// Send a rescan event to every watch.
+func (ino *Inotify) rescan() {
+	ino.mu.Lock()
+	var ws []*Watch
+	for _, wds := range ino.wds {
+		ws = append(ws, wds...)
+	}
+	ino.mu.Unlock()
+
+	for _, w := range ws {
+		w.send(Event{Kind: EventOverflow, Wd: -1, Mask: unix.IN_Q_OVERFLOW})
+	}
+}
+
+func inotifyKind(mask uint32) EventKind {
+	switch {
+	case mask&unix.IN_CREATE != 0:
+		return EventCreate
+	case mask&unix.IN_DELETE != 0:
+		return EventDelete
+	case mask&unix.IN_MOVED_TO != 0:
+		return EventMoveIn
+	case mask&unix.IN_MOVED_FROM != 0:
+		return EventMoveOut
+	default:
+		return EventModify
+	}
+}
+
 func printEvent(ev *unix.InotifyEvent) {
 	switch ev.Mask {
 	case unix.IN_MODIFY:
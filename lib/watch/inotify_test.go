@@ -1,7 +1,10 @@
 package watch
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -220,3 +223,205 @@ func TestInotify_ReceiveEventDifferentFiles(t *testing.T) {
 	ino.Rm(handle1)
 	ino.Rm(handle2)
 }
+
+func TestInotify_SameFileTwoWatches(t *testing.T) {
+	ino, err := NewInotify()
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+	defer ino.Close()
+
+	tmp, err := os.CreateTemp("", "inotest")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	handle1, err := ino.Add(tmp.Name())
+	if err != nil {
+		t.Fatalf("add err: %v", err)
+	}
+
+	handle2, err := ino.Add(tmp.Name())
+	if err != nil {
+		t.Fatalf("add err: %v", err)
+	}
+
+	if len(ino.wds) != 1 {
+		t.Fatal("wrong number of watch descriptors:", len(ino.wds))
+	}
+
+	if _, err := tmp.Write([]byte("Two roads diverged in a wood")); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+
+	for _, handle := range []*WatchHandle{handle1, handle2} {
+		select {
+		case <-handle.Out:
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for event")
+		}
+	}
+
+	// Removing one watch leaves the other in place.
+	if err := ino.Rm(handle1); err != nil {
+		t.Fatalf("rm err: %v", err)
+	}
+
+	if _, ok := ino.wds[handle2.wd]; !ok {
+		t.Fatal("remaining watch gone after Rm")
+	}
+
+	if err := ino.Rm(handle2); err != nil {
+		t.Fatalf("rm err: %v", err)
+	}
+
+	if len(ino.wds) != 0 {
+		t.Fatal("watches left after Rm:", len(ino.wds))
+	}
+}
+
+func TestInotify_DirWatchReceivesName(t *testing.T) {
+	ino, err := NewInotify()
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+	defer ino.Close()
+
+	dir := t.TempDir()
+	handle, err := ino.AddMask(dir, InotifyDirMask)
+	if err != nil {
+		t.Fatalf("add err: %v", err)
+	}
+
+	if err := os.WriteFile(dir+"/created.log", nil, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	select {
+	case ev := <-handle.Out:
+		if ev.Mask&unix.IN_CREATE == 0 || ev.Kind != EventCreate {
+			t.Fatalf("unexpected event: %+v", ev)
+		}
+		if ev.Name != "created.log" {
+			t.Fatalf("unexpected name: '%s'", ev.Name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+
+	ino.Rm(handle)
+}
+
+func TestInotify_AddDirEventKinds(t *testing.T) {
+	ino, err := NewInotify()
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+	defer ino.Close()
+
+	dir := t.TempDir()
+	handle, err := ino.Add(dir)
+	if err != nil {
+		t.Fatalf("add err: %v", err)
+	}
+
+	if err := os.WriteFile(dir+"/a", nil, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.Rename(dir+"/a", dir+"/b"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if err := os.Remove(dir + "/b"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if err := os.Mkdir(dir+"/sub", 0700); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+
+	want := []Event{
+		{Kind: EventCreate, Name: "a"},
+		{Kind: EventMoveOut, Name: "a"},
+		{Kind: EventMoveIn, Name: "b"},
+		{Kind: EventDelete, Name: "b"},
+		{Kind: EventCreate, Name: "sub", Dir: true},
+	}
+	for _, w := range want {
+		select {
+		case ev := <-handle.Out:
+			if ev.Kind != w.Kind || ev.Name != w.Name || ev.Dir != w.Dir {
+				t.Fatalf("unexpected event: %+v, wanted %v %s", ev, w.Kind, w.Name)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for %v %s", w.Kind, w.Name)
+		}
+	}
+
+	ino.Rm(handle)
+}
+
+// The most events the kernel queues for an inotify instance.
+func maxQueuedEvents(t *testing.T) int {
+	t.Helper()
+	b, err := os.ReadFile("/proc/sys/fs/inotify/max_queued_events")
+	if err != nil {
+		t.Skipf("max_queued_events: %v", err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || n > 100000 {
+		t.Skipf("max_queued_events: %q", b)
+	}
+	return n
+}
+
+func TestInotify_OverflowRescan(t *testing.T) {
+	ino, err := NewInotify()
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+	defer ino.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(dir+"/app.log", nil, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	file, err := ino.Add(dir + "/app.log")
+	if err != nil {
+		t.Fatalf("add err: %v", err)
+	}
+	handle, err := ino.AddMask(dir, InotifyDirMask)
+	if err != nil {
+		t.Fatalf("add err: %v", err)
+	}
+
+	// Nothing is read from the handles meanwhile, so the kernel queue fills.
+	for i := range maxQueuedEvents(t) + 1000 {
+		if err := os.WriteFile(fmt.Sprintf("%s/%d", dir, i), nil, 0600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	// Both watches are told, whatever their mask.
+	timeout := time.After(10 * time.Second)
+	var dirRescan, fileRescan bool
+	for !dirRescan || !fileRescan {
+		select {
+		case ev := <-handle.Out:
+			dirRescan = dirRescan || ev.Kind == EventOverflow
+		case ev := <-file.Out:
+			if ev.Kind != EventOverflow {
+				t.Fatalf("unexpected event: %+v", ev)
+			}
+			fileRescan = true
+		case <-timeout:
+			t.Fatal("timeout waiting for rescan")
+		}
+	}
+
+	if n := ino.Overflows(); n != 1 {
+		t.Fatalf("expected 1 overflow, got %d", n)
+	}
+
+	ino.Rm(handle)
+	ino.Rm(file)
+}
@@ -0,0 +1,254 @@
+package watch
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// How often a watched path is looked at right after it changed.
+	PollInterval = 250 * time.Millisecond
+	// The interval doubles every time nothing changed, up to this.
+	PollMaxInterval = 2 * time.Second
+)
+
+// Filesystems where inotify misses changes, by statfs(2) type: those that
+// are changed by other hosts, or by a userspace daemon.
+var pollFilesystems = []int64{
+	unix.NFS_SUPER_MAGIC,
+	unix.SMB_SUPER_MAGIC,
+	unix.SMB2_SUPER_MAGIC,
+	unix.CIFS_SUPER_MAGIC,
+	unix.FUSE_SUPER_MAGIC,
+	unix.CEPH_SUPER_MAGIC,
+	unix.V9FS_MAGIC,
+	unix.AFS_SUPER_MAGIC,
+	unix.AFS_FS_MAGIC,
+	unix.CODA_SUPER_MAGIC,
+	unix.OCFS2_SUPER_MAGIC,
+}
+
+// Whether path is on a filesystem inotify does not work on.
+func NeedsPoll(path string) (bool, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return false, &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+	return slices.Contains(pollFilesystems, int64(st.Type)), nil
+}
+
+// Watches paths by looking at them every so often, for filesystems where
+// inotify events never arrive. A file is fstat(2)ed for changes to its size
+// or mtime, sent as EventModify, or EventTruncate if it shrank. A directory is
+// listed for names that appear, go away or change inode, sent as EventCreate
+// and EventDelete. Each watch backs
+// off while nothing changes, from PollInterval to PollMaxInterval.
+type Poll struct {
+	// synchronizes access to watches
+	mu sync.Mutex
+	// awaits the goroutine of every watch
+	wg sync.WaitGroup
+	// ensure a single call to Close()
+	once sync.Once
+	// watches keyed by a descriptor of their own
+	watches map[int]*pollWatch
+	next    int
+}
+
+type pollWatch struct {
+	w  *Watch
+	wd int
+	// The file, for a file watch, and what fstat last said about it.
+	fp    *os.File
+	size  int64
+	mtime time.Time
+	// The inode of every name in a directory, for a directory watch.
+	names map[string]pollEntry
+}
+
+type pollEntry struct {
+	ino uint64
+	dir bool
+}
+
+func NewPoll() *Poll {
+	return &Poll{watches: make(map[int]*pollWatch)}
+}
+
+// Stop every watch. Their Out channels are closed.
+func (p *Poll) Close() error {
+	p.once.Do(func() {
+		p.mu.Lock()
+		var ws []*pollWatch
+		for _, pw := range p.watches {
+			ws = append(ws, pw)
+		}
+		p.mu.Unlock()
+
+		for _, pw := range ws {
+			_ = p.Rm(&WatchHandle{wd: pw.wd, w: pw.w})
+		}
+		p.wg.Wait()
+	})
+	return nil
+}
+
+// Poll a file for modifications, or a directory for names appearing in and
+// leaving it, taking what is there now as the starting point.
+func (p *Poll) Add(path string) (*WatchHandle, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pw := &pollWatch{w: newWatch(path, 0)}
+
+	st, err := fp.Stat()
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	if st.IsDir() {
+		fp.Close()
+		if pw.names, err = list(path); err != nil {
+			return nil, err
+		}
+	} else {
+		pw.fp = fp
+		pw.size, pw.mtime = st.Size(), st.ModTime()
+	}
+
+	p.mu.Lock()
+	p.next++
+	pw.wd = p.next
+	p.watches[pw.wd] = pw
+	p.mu.Unlock()
+
+	p.wg.Go(func() { pw.poll() })
+
+	return &WatchHandle{wd: pw.wd, w: pw.w, Out: pw.w.out}, nil
+}
+
+func (p *Poll) Rm(handle *WatchHandle) error {
+	p.mu.Lock()
+	_, ok := p.watches[handle.wd]
+	if !ok {
+		p.mu.Unlock()
+		return errors.New("watch not found")
+	}
+	delete(p.watches, handle.wd)
+	p.mu.Unlock()
+
+	// The goroutine closes the file on its way out.
+	handle.w.close()
+	return nil
+}
+
+func (pw *pollWatch) poll() {
+	if pw.fp != nil {
+		defer pw.fp.Close()
+	}
+
+	interval := PollInterval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-pw.w.stop:
+			return
+		case <-timer.C:
+		}
+
+		evs := pw.check()
+		for _, ev := range evs {
+			pw.w.send(ev)
+		}
+
+		if len(evs) > 0 {
+			interval = PollInterval
+		} else {
+			interval = min(2*interval, PollMaxInterval)
+		}
+		timer.Reset(interval)
+	}
+}
+
+// What changed since the last check.
+func (pw *pollWatch) check() []Event {
+	if pw.fp != nil {
+		st, err := pw.fp.Stat()
+		if err != nil || st.Size() == pw.size && st.ModTime().Equal(pw.mtime) {
+			return nil
+		}
+		kind := EventModify
+		if st.Size() < pw.size {
+			kind = EventTruncate
+		}
+		pw.size, pw.mtime = st.Size(), st.ModTime()
+		return []Event{{Kind: kind, Wd: int32(pw.wd), Mask: unix.IN_MODIFY}}
+	}
+
+	// A directory that cannot be read right now, like on a share that went
+	// away for a moment, is looked at again next time rather than taken to
+	// be empty.
+	names, err := list(pw.w.path)
+	if err != nil {
+		return nil
+	}
+	var evs []Event
+	for _, name := range slices.Sorted(maps.Keys(pw.names)) {
+		prev := pw.names[name]
+		if cur, ok := names[name]; !ok || cur.ino != prev.ino {
+			evs = append(evs, pw.event(EventDelete, name, prev))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(names)) {
+		cur := names[name]
+		if prev, ok := pw.names[name]; !ok || cur.ino != prev.ino {
+			evs = append(evs, pw.event(EventCreate, name, cur))
+		}
+	}
+	pw.names = names
+	return evs
+}
+
+// An event about name, with the mask inotify would have sent along.
+func (pw *pollWatch) event(kind EventKind, name string, ent pollEntry) Event {
+	mask := uint32(unix.IN_CREATE)
+	if kind == EventDelete {
+		mask = unix.IN_DELETE
+	}
+	if ent.dir {
+		mask |= unix.IN_ISDIR
+	}
+	return Event{Kind: kind, Dir: ent.dir, Wd: int32(pw.wd), Mask: mask, Name: name}
+}
+
+// The entries in the directory at path.
+func list(path string) (map[string]pollEntry, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]pollEntry)
+	for _, ent := range entries {
+		info, err := ent.Info()
+		if err != nil {
+			continue
+		}
+		var ino uint64
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			ino = st.Ino
+		}
+		names[ent.Name()] = pollEntry{ino: ino, dir: ent.IsDir()}
+	}
+	return names, nil
+}
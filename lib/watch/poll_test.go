@@ -0,0 +1,191 @@
+package watch
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Long enough for a poller that backed off all the way.
+const pollDeadline = 2 * PollMaxInterval
+
+func TestPoll_FileModify(t *testing.T) {
+	p := NewPoll()
+	defer p.Close()
+
+	path := t.TempDir() + "/app.log"
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	handle, err := p.Add(path)
+	if err != nil {
+		t.Fatalf("add err: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("hello\n"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	select {
+	case ev := <-handle.Out:
+		if ev.Kind != EventModify || ev.Mask != unix.IN_MODIFY {
+			t.Fatalf("unexpected event: %+v", ev)
+		}
+	case <-time.After(pollDeadline):
+		t.Fatal("timeout waiting for event")
+	}
+
+	p.Rm(handle)
+	if _, ok := <-handle.Out; ok {
+		t.Fatal("expected closed channel")
+	}
+}
+
+func TestPoll_DirChanges(t *testing.T) {
+	p := NewPoll()
+	defer p.Close()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(dir+"/app.log", nil, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	handle, err := p.Add(dir)
+	if err != nil {
+		t.Fatalf("add err: %v", err)
+	}
+
+	// A file taking the place of another is deleted and created anew.
+	if err := os.WriteFile(dir+"/app.log.new", nil, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.Rename(dir+"/app.log.new", dir+"/app.log"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if err := os.Mkdir(dir+"/sub", 0700); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+
+	want := []Event{
+		{Kind: EventDelete, Mask: unix.IN_DELETE, Name: "app.log"},
+		{Kind: EventCreate, Mask: unix.IN_CREATE, Name: "app.log"},
+		{Kind: EventCreate, Mask: unix.IN_CREATE | unix.IN_ISDIR, Name: "sub", Dir: true},
+	}
+	for _, w := range want {
+		select {
+		case ev := <-handle.Out:
+			if ev.Kind != w.Kind || ev.Mask != w.Mask || ev.Name != w.Name || ev.Dir != w.Dir {
+				t.Fatalf("unexpected event: %+v, wanted %+v", ev, w)
+			}
+		case <-time.After(pollDeadline):
+			t.Fatalf("timeout waiting for %+v", w)
+		}
+	}
+
+	p.Rm(handle)
+}
+
+func TestPoll_DirUnreadable(t *testing.T) {
+	p := NewPoll()
+	defer p.Close()
+
+	parent := t.TempDir()
+	dir := parent + "/share"
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	if err := os.WriteFile(dir+"/app.log", nil, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	handle, err := p.Add(dir)
+	if err != nil {
+		t.Fatalf("add err: %v", err)
+	}
+
+	// Gone for a couple of looks, like a share that failed for a moment.
+	if err := os.Rename(dir, parent+"/away"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	time.Sleep(3 * PollInterval)
+	if err := os.Rename(parent+"/away", dir); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if err := os.WriteFile(dir+"/new.log", nil, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	// Nothing is taken for deleted in the meantime.
+	select {
+	case ev := <-handle.Out:
+		if ev.Kind != EventCreate || ev.Name != "new.log" {
+			t.Fatalf("unexpected event: %+v", ev)
+		}
+	case <-time.After(pollDeadline):
+		t.Fatal("timeout waiting for event")
+	}
+
+	p.Rm(handle)
+}
+
+func TestPoll_FileTruncate(t *testing.T) {
+	p := NewPoll()
+	defer p.Close()
+
+	path := t.TempDir() + "/app.log"
+	if err := os.WriteFile(path, []byte("hello\n"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	handle, err := p.Add(path)
+	if err != nil {
+		t.Fatalf("add err: %v", err)
+	}
+
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatalf("Truncate: %v", err)
+	}
+
+	select {
+	case ev := <-handle.Out:
+		if ev.Kind != EventTruncate {
+			t.Fatalf("unexpected event: %+v", ev)
+		}
+	case <-time.After(pollDeadline):
+		t.Fatal("timeout waiting for event")
+	}
+
+	p.Rm(handle)
+}
+
+func TestPoll_CloseClosesWatches(t *testing.T) {
+	p := NewPoll()
+	handle, err := p.Add(t.TempDir())
+	if err != nil {
+		t.Fatalf("add err: %v", err)
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, ok := <-handle.Out; ok {
+		t.Fatal("expected closed channel")
+	}
+	if err := p.Rm(handle); err == nil {
+		t.Fatal("expected error removing a closed watch")
+	}
+}
+
+func TestNeedsPoll_Local(t *testing.T) {
+	// Where tests run, the temporary directory is expected to be local.
+	poll, err := NeedsPoll(t.TempDir())
+	if err != nil {
+		t.Fatalf("NeedsPoll: %v", err)
+	}
+	if poll {
+		t.Skip("temporary directory on a network filesystem")
+	}
+
+	if _, err := NeedsPoll(t.TempDir() + "/missing"); err == nil {
+		t.Fatal("expected error")
+	}
+}
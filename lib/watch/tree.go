@@ -0,0 +1,338 @@
+package watch
+
+import (
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+type TreeOp uint8
+
+const (
+	TreeCreate TreeOp = iota
+	TreeRemove
+)
+
+func (op TreeOp) String() string {
+	switch op {
+	case TreeCreate:
+		return "create"
+	case TreeRemove:
+		return "remove"
+	default:
+		return "unknown"
+	}
+}
+
+// A file appearing in or leaving a watched tree.
+type TreeEvent struct {
+	Op TreeOp
+	// Slash-separated path relative to the root of the tree.
+	Name string
+	// The file was found by the first scan of the tree, rather than showing
+	// up while it was watched.
+	Initial bool
+	// A directory was removed or moved away, and everything under it with
+	// it. No events are sent for its files.
+	Dir bool
+}
+
+type TreeOptions struct {
+	// Levels of subdirectories watched below the root. Zero watches the
+	// root alone; a negative depth has no limit.
+	MaxDepth int
+	// Whether to watch the subdirectory with the given name, relative to
+	// the root. Nil watches every one.
+	Descend func(name string) bool
+}
+
+// Watches a directory and the subdirectories below it, adding watches as
+// they appear. A directory that appears is scanned right after it is
+// watched, so files created in it before that are not missed. When the
+// watcher loses events the whole tree is scanned again, and the files that
+// came and went meanwhile are sent as if they had been seen.
+type Tree struct {
+	w    Watcher
+	root string
+	opts TreeOptions
+	// Watched directories, by path relative to the root.
+	dirs map[string]*treeDir
+	// Files sent as created and not removed since.
+	files map[string]bool
+	// Overflows of the watcher the tree was last scanned for, if it counts
+	// them. Every watch gets a rescan event; one scan is enough.
+	rescanned uint64
+	// Events from every directory, tagged with the directory.
+	evC  chan treeDirEvent
+	once sync.Once
+	stop chan struct{}
+	done chan struct{}
+	// Files created in and removed from the tree.
+	Out chan TreeEvent
+}
+
+type treeDir struct {
+	handle *WatchHandle
+	depth  int
+}
+
+type treeDirEvent struct {
+	dir string
+	ev  Event
+}
+
+// Counts the times events were lost, like Inotify.
+type overflowCounter interface {
+	Overflows() uint64
+}
+
+// Watch the tree under root with w. Files already in it are sent on Out
+// first, marked Initial.
+func AddTree(w Watcher, root string, opts TreeOptions) (*Tree, error) {
+	t := &Tree{
+		w:     w,
+		root:  root,
+		opts:  opts,
+		dirs:  make(map[string]*treeDir),
+		files: make(map[string]bool),
+		evC:   make(chan treeDirEvent),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+		Out:   make(chan TreeEvent),
+	}
+	if oc, ok := w.(overflowCounter); ok {
+		t.rescanned = oc.Overflows()
+	}
+
+	// The root must be there; anything below may come and go.
+	if err := t.watch("", 0); err != nil {
+		return nil, err
+	}
+
+	go t.run(t.created(t.walk(""), true))
+	return t, nil
+}
+
+// Stop watching the tree. Out is closed once it is done.
+func (t *Tree) Close() {
+	t.once.Do(func() { close(t.stop) })
+	<-t.done
+}
+
+func (t *Tree) run(initial []TreeEvent) {
+	defer close(t.done)
+	defer close(t.Out)
+	defer func() {
+		for _, d := range t.dirs {
+			_ = t.w.Rm(d.handle)
+		}
+	}()
+
+	if !t.sendAll(initial) {
+		return
+	}
+
+	for {
+		select {
+		case <-t.stop:
+			return
+		case de := <-t.evC:
+			if !t.handle(de) {
+				return
+			}
+		}
+	}
+}
+
+// Handle an event from one of the directories. Returns false if the tree
+// was closed.
+func (t *Tree) handle(de treeDirEvent) bool {
+	if de.ev.Kind == EventOverflow {
+		return t.rescan()
+	}
+
+	d, ok := t.dirs[de.dir]
+	if !ok {
+		// Removed while the event was on its way.
+		return true
+	}
+	name := path.Join(de.dir, de.ev.Name)
+	created := de.ev.Appeared()
+	removed := de.ev.Left()
+
+	if !de.ev.Dir {
+		switch {
+		case created:
+			return t.sendAll(t.created([]string{name}, false))
+		case removed && t.files[name]:
+			delete(t.files, name)
+			return t.send(TreeEvent{Op: TreeRemove, Name: name})
+		}
+		return true
+	}
+
+	switch {
+	case created:
+		if !t.descend(name, d.depth+1) {
+			return true
+		}
+		if err := t.watch(name, d.depth+1); err != nil {
+			// Gone already.
+			return true
+		}
+		// Whatever was written before the watch was added.
+		return t.sendAll(t.created(t.walk(name), false))
+	case removed:
+		t.unwatch(name)
+		for file := range t.files {
+			if strings.HasPrefix(file, name+"/") {
+				delete(t.files, file)
+			}
+		}
+		return t.send(TreeEvent{Op: TreeRemove, Name: name, Dir: true})
+	}
+	return true
+}
+
+// Scan the whole tree again after the watcher lost events, and send what was
+// created and removed meanwhile. Returns false if the tree was closed.
+func (t *Tree) rescan() bool {
+	if oc, ok := t.w.(overflowCounter); ok {
+		n := oc.Overflows()
+		if n == t.rescanned {
+			return true
+		}
+		t.rescanned = n
+	}
+
+	// Directories that went away took their watches with them.
+	for name := range t.dirs {
+		if name == "" {
+			continue
+		}
+		if st, err := os.Stat(filepath.Join(t.root, name)); err != nil || !st.IsDir() {
+			t.unwatch(name)
+		}
+	}
+
+	found := t.walk("")
+	seen := make(map[string]bool, len(found))
+	for _, name := range found {
+		seen[name] = true
+	}
+
+	var evs []TreeEvent
+	for _, name := range slices.Sorted(maps.Keys(t.files)) {
+		if !seen[name] {
+			delete(t.files, name)
+			evs = append(evs, TreeEvent{Op: TreeRemove, Name: name})
+		}
+	}
+	evs = append(evs, t.created(found, false)...)
+	return t.sendAll(evs)
+}
+
+// Whether to watch the directory with the given name at the given depth.
+func (t *Tree) descend(name string, depth int) bool {
+	if t.opts.MaxDepth >= 0 && depth > t.opts.MaxDepth {
+		return false
+	}
+	return t.opts.Descend == nil || t.opts.Descend(name)
+}
+
+// Add a watch on the directory with the given name and forward its events.
+func (t *Tree) watch(name string, depth int) error {
+	handle, err := t.w.Add(filepath.Join(t.root, name))
+	if err != nil {
+		return err
+	}
+	t.dirs[name] = &treeDir{handle: handle, depth: depth}
+
+	go func() {
+		// Out is closed when the watch is removed.
+		for ev := range handle.Out {
+			select {
+			case t.evC <- treeDirEvent{dir: name, ev: ev}:
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Remove the watches on the directory with the given name and everything
+// below it.
+func (t *Tree) unwatch(name string) {
+	for dir, d := range t.dirs {
+		if dir == name || strings.HasPrefix(dir, name+"/") {
+			_ = t.w.Rm(d.handle)
+			delete(t.dirs, dir)
+		}
+	}
+}
+
+// List the files in the watched directory with the given name and below,
+// watching the directories found on the way that are not watched yet.
+func (t *Tree) walk(name string) []string {
+	entries, err := os.ReadDir(filepath.Join(t.root, name))
+	if err != nil {
+		return nil
+	}
+
+	var files []string
+	depth := t.dirs[name].depth
+	for _, ent := range entries {
+		child := path.Join(name, ent.Name())
+		if !ent.IsDir() {
+			files = append(files, child)
+			continue
+		}
+
+		if _, ok := t.dirs[child]; !ok {
+			if !t.descend(child, depth+1) {
+				continue
+			}
+			if err := t.watch(child, depth+1); err != nil {
+				continue
+			}
+		}
+		files = append(files, t.walk(child)...)
+	}
+	return files
+}
+
+// Events for the files not sent as created yet, which are from now on.
+func (t *Tree) created(names []string, initial bool) []TreeEvent {
+	var evs []TreeEvent
+	for _, name := range names {
+		if t.files[name] {
+			continue
+		}
+		t.files[name] = true
+		evs = append(evs, TreeEvent{Op: TreeCreate, Name: name, Initial: initial})
+	}
+	return evs
+}
+
+func (t *Tree) send(ev TreeEvent) bool {
+	select {
+	case t.Out <- ev:
+		return true
+	case <-t.stop:
+		return false
+	}
+}
+
+func (t *Tree) sendAll(evs []TreeEvent) bool {
+	for _, ev := range evs {
+		if !t.send(ev) {
+			return false
+		}
+	}
+	return true
+}
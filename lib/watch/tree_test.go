@@ -0,0 +1,230 @@
+package watch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Read events from tree until one satisfies until.
+func treeEvents(t *testing.T, tree *Tree, until func(ev TreeEvent) bool) []TreeEvent {
+	t.Helper()
+	timeout := time.After(time.Second)
+
+	var got []TreeEvent
+	for {
+		select {
+		case ev := <-tree.Out:
+			got = append(got, ev)
+			if until(ev) {
+				return got
+			}
+		case <-timeout:
+			t.Fatalf("timeout; got %+v", got)
+		}
+	}
+}
+
+// Names of the files created, in order, without repeats.
+func created(evs []TreeEvent) []string {
+	var names []string
+	for _, ev := range evs {
+		if ev.Op == TreeCreate && !slices.Contains(names, ev.Name) {
+			names = append(names, ev.Name)
+		}
+	}
+	return names
+}
+
+func mkfile(t *testing.T, root, name string) {
+	t.Helper()
+	p := filepath.Join(root, name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte("x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTree_InitialFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		opts TreeOptions
+		want []string
+	}{
+		{"root only", TreeOptions{}, []string{"a.log"}},
+		{"depth 1", TreeOptions{MaxDepth: 1}, []string{"a.log", "s1/b.log"}},
+		{"no limit", TreeOptions{MaxDepth: -1}, []string{"a.log", "s1/b.log", "s1/s2/c.log"}},
+		{"descend", TreeOptions{MaxDepth: -1, Descend: func(name string) bool { return name != "s1/s2" }}, []string{"a.log", "s1/b.log"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			ino, err := NewInotify()
+			if err != nil {
+				t.Fatalf("got err: %v", err)
+			}
+			defer ino.Close()
+
+			root := t.TempDir()
+			for _, name := range []string{"a.log", "s1/b.log", "s1/s2/c.log"} {
+				mkfile(t, root, name)
+			}
+
+			tree, err := AddTree(ino, root, tc.opts)
+			if err != nil {
+				t.Fatalf("AddTree: %v", err)
+			}
+			defer tree.Close()
+
+			// A marker created after the scan comes after the initial files.
+			mkfile(t, root, "z")
+			got := treeEvents(t, tree, func(ev TreeEvent) bool { return ev.Name == "z" && !ev.Initial })
+			for _, ev := range got[:len(got)-1] {
+				if !ev.Initial {
+					t.Fatalf("expected initial event, got %+v", ev)
+				}
+			}
+			names := created(got[:len(got)-1])
+			slices.Sort(names)
+			if !slices.Equal(names, tc.want) {
+				t.Fatalf("unexpected files: %v", names)
+			}
+		})
+	}
+}
+
+func TestTree_NewSubdirectories(t *testing.T) {
+	ino, err := NewInotify()
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+	defer ino.Close()
+
+	root := t.TempDir()
+	tree, err := AddTree(ino, root, TreeOptions{MaxDepth: -1})
+	if err != nil {
+		t.Fatalf("AddTree: %v", err)
+	}
+	defer tree.Close()
+
+	// Written right after mkdir, likely before the new directories are
+	// watched; the scan picks them up.
+	mkfile(t, root, "out/web/link/log.txt")
+	got := treeEvents(t, tree, func(ev TreeEvent) bool { return ev.Name == "out/web/link/log.txt" })
+	if ev := got[len(got)-1]; ev.Op != TreeCreate || ev.Initial {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+
+	// Watched now.
+	mkfile(t, root, "out/web/link/log.2.txt")
+	treeEvents(t, tree, func(ev TreeEvent) bool { return ev.Name == "out/web/link/log.2.txt" })
+
+	if err := os.RemoveAll(filepath.Join(root, "out")); err != nil {
+		t.Fatal(err)
+	}
+	got = treeEvents(t, tree, func(ev TreeEvent) bool { return ev.Name == "out" })
+	if ev := got[len(got)-1]; ev.Op != TreeRemove || !ev.Dir {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+
+	// Watches below a removed directory are gone.
+	tree.Close()
+	ino.mu.Lock()
+	n := len(ino.wds)
+	ino.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("%d watches left", n)
+	}
+}
+
+func TestTree_MovedDirectory(t *testing.T) {
+	ino, err := NewInotify()
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+	defer ino.Close()
+
+	root := t.TempDir()
+	elsewhere := t.TempDir()
+	mkfile(t, elsewhere, "step/log.txt")
+
+	tree, err := AddTree(ino, root, TreeOptions{MaxDepth: 1})
+	if err != nil {
+		t.Fatalf("AddTree: %v", err)
+	}
+	defer tree.Close()
+
+	if err := os.Rename(filepath.Join(elsewhere, "step"), filepath.Join(root, "step")); err != nil {
+		t.Fatal(err)
+	}
+	got := treeEvents(t, tree, func(ev TreeEvent) bool { return ev.Name == "step/log.txt" })
+	if ev := got[len(got)-1]; ev.Op != TreeCreate {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+
+	if err := os.Rename(filepath.Join(root, "step"), filepath.Join(elsewhere, "step")); err != nil {
+		t.Fatal(err)
+	}
+	got = treeEvents(t, tree, func(ev TreeEvent) bool { return ev.Name == "step" })
+	if ev := got[len(got)-1]; ev.Op != TreeRemove || !ev.Dir {
+		t.Fatalf("unexpected event: %+v", ev)
+	}
+}
+
+func TestTree_Overflow(t *testing.T) {
+	ino, err := NewInotify()
+	if err != nil {
+		t.Fatalf("got err: %v", err)
+	}
+	defer ino.Close()
+
+	root := t.TempDir()
+	mkfile(t, root, "gone.log")
+	tree, err := AddTree(ino, root, TreeOptions{})
+	if err != nil {
+		t.Fatalf("AddTree: %v", err)
+	}
+	defer tree.Close()
+	treeEvents(t, tree, func(ev TreeEvent) bool { return ev.Name == "gone.log" })
+
+	// Nothing is read from the tree meanwhile, so the kernel queue fills
+	// and the removal is likely lost; the rescan finds it.
+	n := maxQueuedEvents(t) + 1000
+	for i := range n {
+		mkfile(t, root, fmt.Sprintf("%d", i))
+	}
+	if err := os.Remove(filepath.Join(root, "gone.log")); err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := time.After(10 * time.Second)
+	files := 0
+	for removed := false; !removed || files < n; {
+		select {
+		case ev := <-tree.Out:
+			switch {
+			case ev.Op == TreeRemove && ev.Name == "gone.log":
+				removed = true
+			case ev.Op == TreeCreate:
+				files++
+			default:
+				t.Fatalf("unexpected event: %+v", ev)
+			}
+		case <-timeout:
+			t.Fatalf("timeout; %d of %d files", files, n)
+		}
+	}
+	// Every file once, whether seen or found by the rescan.
+	if files != n {
+		t.Fatalf("expected %d files, got %d", n, files)
+	}
+	if ino.Overflows() == 0 {
+		t.Fatal("expected an overflow")
+	}
+}
@@ -0,0 +1,133 @@
+package watch
+
+import (
+	"sync"
+)
+
+// Tells file sources about changes to the files and directories they watch.
+// Inotify is the usual one; Poll works where inotify does not, and Fake is
+// driven by tests.
+type Watcher interface {
+	// Watch the file or directory at path. A file reports changes to it; a
+	// directory reports names appearing in and leaving it.
+	Add(path string) (*WatchHandle, error)
+	// Stop watching. The Out channel of the handle is closed.
+	Rm(handle *WatchHandle) error
+	Close() error
+}
+
+type EventKind uint8
+
+const (
+	// The file was written to.
+	EventModify EventKind = iota
+	// The file got shorter. Inotify cannot tell, and reports a modify.
+	EventTruncate
+	// A name was created in the directory.
+	EventCreate
+	// A name was deleted from the directory.
+	EventDelete
+	// A name was moved into the directory.
+	EventMoveIn
+	// A name was moved out of the directory.
+	EventMoveOut
+	// Events were lost, and whatever the watch follows should be looked at
+	// again. Sent to every watch.
+	EventOverflow
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventModify:
+		return "modify"
+	case EventTruncate:
+		return "truncate"
+	case EventCreate:
+		return "create"
+	case EventDelete:
+		return "delete"
+	case EventMoveIn:
+		return "move-in"
+	case EventMoveOut:
+		return "move-out"
+	case EventOverflow:
+		return "overflow"
+	default:
+		return "unknown"
+	}
+}
+
+type Event struct {
+	Kind EventKind
+	// The name in a watched directory the event is about, and whether it
+	// is a directory itself.
+	Name string
+	Dir  bool
+	// As read from inotify. Mask is also set by Poll.
+	Wd     int32
+	Cookie uint32
+	Mask   uint32
+}
+
+// Whether a name appeared in a watched directory.
+func (ev Event) Appeared() bool {
+	return ev.Kind == EventCreate || ev.Kind == EventMoveIn
+}
+
+// Whether a name left a watched directory.
+func (ev Event) Left() bool {
+	return ev.Kind == EventDelete || ev.Kind == EventMoveOut
+}
+
+type Watch struct {
+	path   string
+	offset int
+	// Events this watch is interested in.
+	mask uint32
+	out  chan Event
+	// Closed by Rm() to preempt a blocked send on out.
+	stop chan struct{}
+	// Serializes sends on out with closing it.
+	mu     sync.Mutex
+	closed bool
+}
+
+type WatchHandle struct {
+	wd  int
+	w   *Watch
+	Out chan Event
+}
+
+func newWatch(path string, mask uint32) *Watch {
+	return &Watch{
+		path: path,
+		mask: mask,
+		out:  make(chan Event),
+		stop: make(chan struct{}),
+	}
+}
+
+// Deliver ev to w, unless the watch is removed in the meantime. Returns
+// whether it was delivered.
+func (w *Watch) send(ev Event) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return false
+	}
+	select {
+	case w.out <- ev:
+		return true
+	case <-w.stop:
+		return false
+	}
+}
+
+// Unblock a pending send, then close out once it is done.
+func (w *Watch) close() {
+	close(w.stop)
+	w.mu.Lock()
+	w.closed = true
+	close(w.out)
+	w.mu.Unlock()
+}